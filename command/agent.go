@@ -0,0 +1,307 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	vt "github.com/mauromedda/vauth/command/token"
+	"github.com/mauromedda/vauth/pkg/login"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// agentMinBackoff and agentMaxBackoff bound the wait between two failed
+	// logins of the agent.
+	agentMinBackoff = time.Second
+	agentMaxBackoff = time.Minute
+)
+
+// parseSink parses a --sink value into the sink writing the token with the
+// permissions mode. The value is file:<path> followed by the comma separated
+// options of the Vault Agent sinks:
+//
+//	wrap_ttl=<duration>    write a response-wrapping token, wrapped with client
+//	dh_type=curve25519     encrypt the token for the public key in dh_path
+//	dh_path=<path>         JSON file with the curve25519_public_key
+//	aad=<string>           additional authenticated data of the encryption
+//
+// e.g. file:/vault/token,dh_type=curve25519,dh_path=/vault/consumer.json.
+func parseSink(value string, mode os.FileMode, client *api.Client) (vt.Sink, error) {
+	fields := strings.Split(value, ",")
+	parts := strings.SplitN(fields[0], ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid sink %q, expected file:<path>", value)
+	}
+	if parts[0] != "file" {
+		return nil, fmt.Errorf("%s sink not supported", parts[0])
+	}
+	options := make(map[string]string)
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid sink option %q, expected k=v", f)
+		}
+		switch kv[0] {
+		case "wrap_ttl", "dh_type", "dh_path", "aad":
+			options[kv[0]] = kv[1]
+		default:
+			return nil, fmt.Errorf("%s sink option not supported", kv[0])
+		}
+	}
+
+	var sink vt.Sink = &vt.FileSink{Path: filepath.Clean(parts[1]), Mode: mode}
+	switch options["dh_type"] {
+	case "":
+		if options["dh_path"] != "" || options["aad"] != "" {
+			return nil, fmt.Errorf("dh_path and aad require dh_type")
+		}
+	case "curve25519":
+		if options["dh_path"] == "" {
+			return nil, fmt.Errorf("dh_type requires dh_path")
+		}
+		publicKey, err := vt.ReadPublicKey(options["dh_path"])
+		if err != nil {
+			return nil, err
+		}
+		sink = &vt.EncryptedSink{PublicKey: publicKey, AAD: options["aad"], Sink: sink}
+	default:
+		return nil, fmt.Errorf("%s dh_type not supported", options["dh_type"])
+	}
+	if options["wrap_ttl"] != "" {
+		ttl, err := time.ParseDuration(options["wrap_ttl"])
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid wrap_ttl %q", options["wrap_ttl"])
+		}
+		// Wrap first, the encrypted sink gets the wrapping info
+		sink = &vt.WrappedSink{Client: client, TTL: ttl, Sink: sink}
+	}
+	return sink, nil
+}
+
+// multiSink stores the token in all its sinks.
+type multiSink []vt.Sink
+
+// Store implements login.TokenSink.
+func (m multiSink) Store(token string) error {
+	for _, s := range m {
+		if err := s.Store(token); err != nil {
+			return fmt.Errorf("error writing the sink: %s", err)
+		}
+	}
+	return nil
+}
+
+// agent logs in, writes the token to the sinks and keeps it renewed: a small
+// subset of the Vault Agent auto-auth.
+type agent struct {
+	opts  login.Options
+	sinks multiSink
+}
+
+// authenticate logs in writing the token to the sinks. The logins failed
+// because Vault is unavailable, sealed or slow are retried with an
+// exponential backoff until they succeed or ctx is done; the other failures,
+// e.g. a denied login, are returned at once. When only the sinks fail the
+// token is kept and the sink write alone is retried.
+func (a *agent) authenticate(ctx context.Context) (*api.Secret, error) {
+	opts := a.opts
+	opts.TokenSink = a.sinks
+	backoff := agentMinBackoff
+	for {
+		sec, err := login.Authenticate(ctx, opts)
+		switch {
+		case err == nil:
+			logger.Info("agent authenticated", "sinks", len(a.sinks))
+			return sec, nil
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case errors.Is(err, login.ErrPersist):
+			return a.storeToken(ctx, sec, err)
+		case !errors.Is(err, login.ErrUnavailable) && !errors.Is(err, login.ErrSealed) && !errors.Is(err, login.ErrTimeout):
+			return nil, err
+		}
+		logger.Warn("agent authentication failed, retrying", "backoff", backoff, "error", err)
+		if backoff, err = waitBackoff(ctx, backoff); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// storeToken retries writing the token of sec to the sinks, after the write
+// of the login failed with err, until it succeeds or ctx is done.
+func (a *agent) storeToken(ctx context.Context, sec *api.Secret, err error) (*api.Secret, error) {
+	token, _ := sec.TokenID()
+	backoff := agentMinBackoff
+	for {
+		logger.Warn("agent sink write failed, retrying", "backoff", backoff, "error", err)
+		if backoff, err = waitBackoff(ctx, backoff); err != nil {
+			return nil, err
+		}
+		if err = a.sinks.Store(token); err == nil {
+			logger.Info("agent authenticated", "sinks", len(a.sinks))
+			return sec, nil
+		}
+	}
+}
+
+// waitBackoff waits backoff, or until ctx is done, and returns the next
+// backoff of the exponential backoff of the agent.
+func waitBackoff(ctx context.Context, backoff time.Duration) (time.Duration, error) {
+	select {
+	case <-ctx.Done():
+		return backoff, ctx.Err()
+	case <-time.After(backoff):
+	}
+	if backoff *= 2; backoff > agentMaxBackoff {
+		backoff = agentMaxBackoff
+	}
+	return backoff, nil
+}
+
+// keepRenewed renews the token of sec at two thirds of its TTL. It returns
+// nil when the token cannot be renewed anymore, because it is not renewable,
+// the renewal failed or the TTL is close to the max TTL, and a new login is
+// needed.
+func (a *agent) keepRenewed(ctx context.Context, sec *api.Secret) error {
+	if sec.Auth == nil {
+		return fmt.Errorf("no auth information in the login response")
+	}
+	lease := sec.Auth.LeaseDuration
+	if lease <= 0 {
+		// The token never expires
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	client, err := login.NewClientWithLogger(ctx, a.opts.Config, logger)
+	if err != nil {
+		return err
+	}
+	client.SetToken(sec.Auth.ClientToken)
+
+	ttl, renewable := lease, sec.Auth.Renewable
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(ttl) * time.Second * 2 / 3):
+		}
+		if !renewable {
+			return nil
+		}
+		renewed, err := client.Auth().Token().RenewSelf(lease)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || renewed == nil || renewed.Auth == nil {
+			logger.Warn("agent token renewal failed, logging in again", "error", err)
+			return nil
+		}
+		ttl, renewable = renewed.Auth.LeaseDuration, renewed.Auth.Renewable
+		if ttl <= lease/3 {
+			// Close to the max TTL, get a new token before this one expires
+			return nil
+		}
+	}
+}
+
+// run logs in and, unless exitAfterAuth, keeps the token renewed logging in
+// again when needed until ctx is done.
+func (a *agent) run(ctx context.Context, exitAfterAuth bool) error {
+	for {
+		sec, err := a.authenticate(ctx)
+		if err != nil {
+			return err
+		}
+		if exitAfterAuth {
+			return nil
+		}
+		if err := a.keepRenewed(ctx, sec); err != nil {
+			return err
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.Flags().StringArray("sink", nil, `Destination of the token, file:<path>[,wrap_ttl=5m][,dh_type=curve25519,dh_path=<path>[,aad=<string>]].
+Repeat the flag for more sinks`)
+	agentCmd.Flags().String("sink-mode", "0640", "Permissions of the token files")
+	agentCmd.Flags().Bool("exit-after-auth", false, "Exit after the first successful login, e.g. in an init container")
+	addLoginFlags(agentCmd)
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent --sink file:<path> [--exit-after-auth] -m method [K=V...]",
+	Short: "Log in and keep a token renewed in one or more sinks",
+	Long: `This subcommand logs in with the provided method, writes the token atomically
+to the sinks and keeps it renewed, logging in again when it cannot be renewed
+anymore, e.g. as a Kubernetes sidecar:
+
+    $ vauth agent --sink file:/vault/token --sink-mode 0640 -m kubernetes role=app
+
+The sinks accept the wrap_ttl option, to write a response-wrapping token instead
+of the token, and the dh_type=curve25519 and dh_path options, to encrypt the token
+for the consumer public key in the format of the Vault Agent dh sinks:
+
+    $ vauth sink keygen --public-key /vault/consumer.json --private-key app.json
+    $ vauth agent --sink file:/vault/token,wrap_ttl=5m,dh_type=curve25519,dh_path=/vault/consumer.json ...
+    $ vauth sink decrypt --private-key app.json --unwrap /vault/token
+
+With --exit-after-auth it exits after the first login, e.g. as an init container.
+The logins failed because Vault is unavailable, sealed or slow are retried with
+an exponential backoff; the agent exits at once on the other failures, e.g. a
+denied login. --timeout bounds the whole run, not only the wait: the agent exits
+with code 124 once it expires.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sinkValues, err := cmd.Flags().GetStringArray("sink")
+		if err != nil {
+			return err
+		}
+		modeFlag, err := cmd.Flags().GetString("sink-mode")
+		if err != nil {
+			return err
+		}
+		exitAfterAuth, err := cmd.Flags().GetBool("exit-after-auth")
+		if err != nil {
+			return err
+		}
+		mode, err := strconv.ParseUint(modeFlag, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid --sink-mode %q", modeFlag)
+		}
+		if len(sinkValues) == 0 {
+			return fmt.Errorf("at least a --sink is required")
+		}
+		opts, err := loginOptions(cmd, args)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := login.NewClientWithLogger(ctx, opts.Config, logger)
+		if err != nil {
+			return err
+		}
+		var sinks multiSink
+		for _, v := range sinkValues {
+			sink, err := parseSink(v, os.FileMode(mode), client)
+			if err != nil {
+				return err
+			}
+			sinks = append(sinks, sink)
+		}
+		cmd.SilenceUsage = true
+
+		a := &agent{opts: opts, sinks: sinks}
+		return a.run(ctx, exitAfterAuth)
+	},
+}
@@ -0,0 +1,151 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	vt "github.com/mauromedda/vauth/command/token"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+	"github.com/mauromedda/vauth/pkg/login"
+)
+
+func TestParseSink(t *testing.T) {
+	sink, err := parseSink("file:/vault/token", 0640, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file, ok := sink.(*vt.FileSink); !ok || file.Path != "/vault/token" || file.Mode != 0640 {
+		t.Fatalf("unexpected sink %#v", sink)
+	}
+	for _, value := range []string{"/vault/token", "file:", "s3:bucket/token"} {
+		if _, err := parseSink(value, 0640, nil); err == nil {
+			t.Fatalf("expected an error parsing %q", value)
+		}
+	}
+}
+
+func TestAgentExitAfterAuth(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddUser("userpass", "app", "secret", "app")
+	defer withVault(vault)()
+	dir, err := ioutil.TempDir("", "vauth-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sinks := multiSink{
+		&vt.FileSink{Path: filepath.Join(dir, "token"), Mode: 0640},
+		&vt.FileSink{Path: filepath.Join(dir, "token-copy"), Mode: 0600},
+	}
+	a := &agent{
+		opts: login.Options{
+			Method: "userpass",
+			Params: map[string]string{"username": "app", "password": "secret"},
+		},
+		sinks: sinks,
+	}
+	if err := a.run(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sinks {
+		sink := s.(*vt.FileSink)
+		b, err := ioutil.ReadFile(sink.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := vault.LookupToken(string(b)); !ok {
+			t.Fatalf("unexpected token %q in %s", b, sink.Path)
+		}
+		fi, err := os.Stat(sink.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fi.Mode().Perm(), sink.Mode; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	// A denied login is not retried
+	a.opts.Params["password"] = "wrong"
+	if err := a.run(context.Background(), true); exitCode(err) != ExitCodeAuthDenied {
+		t.Fatalf("got exit code %d (%v), want %d", exitCode(err), err, ExitCodeAuthDenied)
+	}
+
+	// The logins failed while Vault is unavailable are retried until ctx is done
+	a.opts.Params["password"] = "secret"
+	vault.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := a.run(ctx, true); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// flakySink fails the first writes and records the stored tokens.
+type flakySink struct {
+	failures int
+	tokens   []string
+}
+
+func (s *flakySink) Store(token string) error {
+	s.tokens = append(s.tokens, token)
+	if len(s.tokens) <= s.failures {
+		return fmt.Errorf("sink unavailable")
+	}
+	return nil
+}
+
+func TestAgentRetriesSinks(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddUser("userpass", "app", "secret", "app")
+	defer withVault(vault)()
+
+	sink := &flakySink{failures: 1}
+	a := &agent{
+		opts: login.Options{
+			Method: "userpass",
+			Params: map[string]string{"username": "app", "password": "secret"},
+		},
+		sinks: multiSink{sink},
+	}
+	sec, err := a.authenticate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The token is written again, without logging in again
+	if len(sink.tokens) != 2 || sink.tokens[0] != sec.Auth.ClientToken || sink.tokens[1] != sec.Auth.ClientToken {
+		t.Fatalf("got tokens %v, want %s twice", sink.tokens, sec.Auth.ClientToken)
+	}
+}
+
+func TestAgentKeepRenewed(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	defer withVault(vault)()
+	token := vault.CreateToken("app")
+	token.Renewable = false
+	a := &agent{}
+
+	// A failed renewal requires a new login
+	sec := &api.Secret{Auth: &api.SecretAuth{ClientToken: token.ID, LeaseDuration: 1, Renewable: true}}
+	if err := a.keepRenewed(context.Background(), sec); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token never expiring is kept until ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sec = &api.Secret{Auth: &api.SecretAuth{ClientToken: vault.RootToken}}
+	if err := a.keepRenewed(ctx, sec); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
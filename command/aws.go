@@ -0,0 +1,167 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// awsCredentials is the output of an AWS credential_process.
+type awsCredentials struct {
+	Version         int    `json:"Version"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+// expiresWithin reports whether the credentials expire within d from now.
+func (c *awsCredentials) expiresWithin(now time.Time, d time.Duration) bool {
+	if c.Expiration == "" {
+		return false
+	}
+	expiration, err := time.Parse(time.RFC3339, c.Expiration)
+	if err != nil {
+		return true
+	}
+	return expiration.Sub(now) <= d
+}
+
+// awsCredsRequest identifies the credentials read from the AWS secrets
+// engine.
+type awsCredsRequest struct {
+	mount   string
+	role    string
+	roleARN string
+	ttl     string
+}
+
+// readAWSCredentials reads new credentials from the AWS secrets engine.
+func readAWSCredentials(client *api.Client, r awsCredsRequest, now time.Time) (*awsCredentials, error) {
+	data := map[string]interface{}{}
+	if r.roleARN != "" {
+		data["role_arn"] = r.roleARN
+	}
+	if r.ttl != "" {
+		data["ttl"] = r.ttl
+	}
+	secret, err := client.Logical().Write(fmt.Sprintf("%s/creds/%s", r.mount, r.role), data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("no credentials returned for role %s", r.role)
+	}
+
+	creds := &awsCredentials{Version: 1}
+	creds.AccessKeyID, _ = secret.Data["access_key"].(string)
+	creds.SecretAccessKey, _ = secret.Data["secret_key"].(string)
+	creds.SessionToken, _ = secret.Data["security_token"].(string)
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("incomplete credentials returned for role %s", r.role)
+	}
+	if secret.LeaseDuration > 0 {
+		lease := time.Duration(secret.LeaseDuration) * time.Second
+		creds.Expiration = now.Add(lease).UTC().Format(time.RFC3339)
+	}
+	return creds, nil
+}
+
+// awsCredentialProcess returns the cached credentials for r if they do not
+// expire within refreshBefore, otherwise it reads and caches new ones. The
+// cache entries are per stored token: a login as another identity never
+// gets the credentials of the previous one.
+func awsCredentialProcess(ctx context.Context, r awsCredsRequest, cache string, refreshBefore time.Duration) (*awsCredentials, error) {
+	now := time.Now()
+	token, err := storedToken()
+	if err != nil {
+		return nil, err
+	}
+	// The key is a hash: the token is never written to the cache
+	key := cacheKey(os.Getenv(api.EnvVaultAddress), os.Getenv(api.EnvVaultNamespace), token, r.mount, r.role, r.roleARN, r.ttl)
+	var cached awsCredentials
+	if readCache(cache, key, &cached) && !cached.expiresWithin(now, refreshBefore) {
+		return &cached, nil
+	}
+
+	client, err := sessionClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := readAWSCredentials(client, r, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCache(cache, key, creds); err != nil {
+		logger.Warn("error caching the AWS credentials", "cache", cache, "error", err)
+	}
+	return creds, nil
+}
+
+func init() {
+	rootCmd.AddCommand(awsCmd)
+	awsCmd.AddCommand(awsCredentialProcessCmd)
+	awsCredentialProcessCmd.Flags().String("role", "", "Role of the AWS secrets engine")
+	awsCredentialProcessCmd.Flags().String("mount", "aws", "Path where the AWS secrets engine is mounted")
+	awsCredentialProcessCmd.Flags().String("role-arn", "", "ARN of the role to assume when the Vault role has more than one")
+	awsCredentialProcessCmd.Flags().String("ttl", "", "TTL of the STS credentials (e.g. 1h)")
+	awsCredentialProcessCmd.Flags().Duration("refresh-before", 5*time.Minute, "Read new credentials when the cached ones expire within this duration")
+}
+
+var awsCmd = &cobra.Command{
+	Use:   "aws",
+	Short: "Interact with the Vault AWS secrets engine",
+}
+
+var awsCredentialProcessCmd = &cobra.Command{
+	Use:   "credential-process",
+	Short: "AWS credential_process provider backed by the Vault AWS secrets engine",
+	Long: `This subcommand reads credentials from the Vault AWS secrets engine with the
+stored token and prints them in the format expected by the AWS credential_process
+setting. The credentials are cached on disk until shortly before they expire, e.g.
+
+    [profile deploy]
+    credential_process = vauth aws credential-process --role deploy
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var r awsCredsRequest
+		var err error
+		if r.role, err = cmd.Flags().GetString("role"); err != nil {
+			return err
+		}
+		if r.role == "" {
+			return fmt.Errorf("No role provided")
+		}
+		if r.mount, err = cmd.Flags().GetString("mount"); err != nil {
+			return err
+		}
+		if r.roleARN, err = cmd.Flags().GetString("role-arn"); err != nil {
+			return err
+		}
+		if r.ttl, err = cmd.Flags().GetString("ttl"); err != nil {
+			return err
+		}
+		refreshBefore, err := cmd.Flags().GetDuration("refresh-before")
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+
+		cache, err := cacheDir("aws")
+		if err != nil {
+			return err
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		creds, err := awsCredentialProcess(ctx, r, cache, refreshBefore)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(os.Stdout).Encode(creds)
+	},
+}
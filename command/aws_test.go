@@ -0,0 +1,107 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestAWSCredentialProcess(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	defer withVault(vault)()
+	_, restore := withHome(t)
+	defer restore()
+
+	var calls int32
+	vault.HandleFunc("/v1/aws/creds/deploy", func(w http.ResponseWriter, r *http.Request) {
+		if vault.Authorized(w, r) == nil {
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		vaulttest.RespondJSON(w, http.StatusOK, map[string]interface{}{
+			"lease_id":       "aws/creds/deploy/1",
+			"lease_duration": 3600,
+			"data": map[string]interface{}{
+				"access_key":     "ASIAEXAMPLE",
+				"secret_key":     "secret",
+				"security_token": "session",
+			},
+		})
+	})
+
+	cache, err := ioutil.TempDir("", "vauth-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache)
+
+	r := awsCredsRequest{mount: "aws", role: "deploy"}
+	ctx := context.Background()
+	creds, err := awsCredentialProcess(ctx, r, cache, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Version != 1 || creds.AccessKeyID != "ASIAEXAMPLE" || creds.SecretAccessKey != "secret" || creds.SessionToken != "session" {
+		t.Fatalf("unexpected credentials %+v", creds)
+	}
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiration); d < 59*time.Minute || d > time.Hour {
+		t.Fatalf("unexpected expiration %s", creds.Expiration)
+	}
+
+	// The cached credentials are still fresh
+	if _, err := awsCredentialProcess(ctx, r, cache, 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("got %d calls, want 1", got)
+	}
+
+	// The cached credentials expire within the refresh window
+	if _, err := awsCredentialProcess(ctx, r, cache, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("got %d calls, want 2", got)
+	}
+
+	files, _ := ioutil.ReadDir(cache)
+	if len(files) != 1 || files[0].Mode().Perm() != 0600 {
+		t.Fatalf("expected one cache entry readable only by the user, got %v", files)
+	}
+
+	// Another token does not get the cached credentials
+	os.Setenv("VAULT_TOKEN", vault.CreateToken("default").ID)
+	if _, err := awsCredentialProcess(ctx, r, cache, 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("got %d calls, want 3", got)
+	}
+}
+
+func TestAWSCredentialProcessNoToken(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	defer withVault(vault)()
+	os.Setenv("VAULT_TOKEN", "s.wrong")
+
+	cache, err := ioutil.TempDir("", "vauth-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache)
+	if _, err := awsCredentialProcess(context.Background(), awsCredsRequest{mount: "aws", role: "deploy"}, cache, time.Minute); err == nil {
+		t.Fatal("expected an error with an invalid token")
+	}
+}
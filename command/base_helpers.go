@@ -5,7 +5,6 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"io"
-	"os"
 	"strings"
 )
 
@@ -54,27 +53,6 @@ func parseArgsDataStringLists(stdin io.Reader, args []string) (map[string][]stri
 	return result, nil
 }
 
-// UsernameFromEnv returns the username stored into LOGNAME or USER if defined or
-// the empty string
-func UsernameFromEnv() string {
-	if logname := os.Getenv("LOGNAME"); logname != "" {
-		return logname
-	}
-	if user := os.Getenv("USER"); user != "" {
-		return user
-	}
-	return ""
-}
-
-// PasswordFromEnv returns the password stored into PASSWORD or
-// the empty string
-func PasswordFromEnv() string {
-	if password := os.Getenv("PASSWORD"); password != "" {
-		return password
-	}
-	return ""
-}
-
 // EnsureTrailingSlash ensures the given string has a trailing slash.
 func EnsureTrailingSlash(s string) string {
 	s = strings.TrimSpace(s)
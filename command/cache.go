@@ -0,0 +1,52 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// cacheDir returns the directory caching the credentials of kind (e.g. aws),
+// ~/.vauth/cache/<kind>.
+func cacheDir(kind string) (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".vauth", "cache", kind), nil
+}
+
+// cacheKey returns the name of the cache entry identified by parts.
+func cacheKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:16]) + ".json"
+}
+
+// readCache decodes into v the cache entry dir/key. It returns false if the
+// entry is missing or unreadable.
+func readCache(dir, key string, v interface{}) bool {
+	b, err := ioutil.ReadFile(filepath.Join(dir, key))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(b, v) == nil
+}
+
+// writeCache atomically stores v, encoded as JSON, in the cache entry
+// dir/key readable only by the user.
+func writeCache(dir, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, key), b, 0600)
+}
@@ -0,0 +1,307 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// capabilityCheck is a path and the capabilities required on it.
+type capabilityCheck struct {
+	path     string
+	required []string
+}
+
+// readCapabilityChecks reads the checks of a --from-file list: one path per
+// line, optionally preceded by the comma separated capabilities required on
+// it (e.g. "read,list secret/data/app"). The paths without capabilities
+// require the defaults. Blank lines and lines starting with # are ignored.
+func readCapabilityChecks(r io.Reader, defaults []string) ([]capabilityCheck, error) {
+	var checks []capabilityCheck
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			checks = append(checks, capabilityCheck{path: fields[0], required: defaults})
+		case 2:
+			checks = append(checks, capabilityCheck{path: fields[1], required: strings.Split(fields[0], ",")})
+		default:
+			return nil, fmt.Errorf("line %d: expected [capability[,capability...]] path", n)
+		}
+	}
+	return checks, scanner.Err()
+}
+
+// readCapabilityFile reads the checks of the --from-file list at path, "-"
+// meaning the standard input.
+func readCapabilityFile(path string, defaults []string) ([]capabilityCheck, error) {
+	if path == "-" {
+		return readCapabilityChecks(os.Stdin, defaults)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	checks, err := readCapabilityChecks(f, defaults)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return checks, nil
+}
+
+// canChecks returns the checks of the can arguments, the capability and the
+// paths requiring it, and of the --from-file list, if not empty. The
+// capability argument is optional with --from-file.
+func canChecks(args []string, fromFile string) ([]capabilityCheck, error) {
+	var defaults []string
+	var checks []capabilityCheck
+	if len(args) > 0 {
+		defaults = []string{args[0]}
+		for _, path := range args[1:] {
+			checks = append(checks, capabilityCheck{path: path, required: defaults})
+		}
+	}
+	if fromFile != "" {
+		fileChecks, err := readCapabilityFile(fromFile, defaults)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, fileChecks...)
+	}
+	for _, c := range checks {
+		if len(c.required) == 0 {
+			return nil, fmt.Errorf("no capability required on %s", c.path)
+		}
+	}
+	if len(checks) == 0 {
+		return nil, fmt.Errorf("no path to check")
+	}
+	return checks, nil
+}
+
+// capabilitiesSelf returns the capabilities of the client token on each of
+// the paths, read with a single sys/capabilities-self request. The paths
+// listed more than once are requested once.
+func capabilitiesSelf(client *api.Client, paths []string) (map[string][]string, error) {
+	seen := make(map[string]bool, len(paths))
+	unique := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	paths = unique
+	secret, err := client.Logical().Write("sys/capabilities-self", map[string]interface{}{
+		"paths": paths,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("no capabilities returned")
+	}
+	capabilities := make(map[string][]string, len(paths))
+	for _, path := range paths {
+		raw, ok := secret.Data[path].([]interface{})
+		if !ok && len(paths) == 1 {
+			raw, ok = secret.Data["capabilities"].([]interface{})
+		}
+		if !ok {
+			return nil, fmt.Errorf("no capabilities returned for %s", path)
+		}
+		for _, c := range raw {
+			capabilities[path] = append(capabilities[path], fmt.Sprint(c))
+		}
+	}
+	return capabilities, nil
+}
+
+// missingCapabilities returns the required capabilities not granted by has.
+// The root capability grants all of them.
+func missingCapabilities(has, required []string) []string {
+	granted := make(map[string]bool, len(has))
+	for _, c := range has {
+		granted[c] = true
+	}
+	if granted["root"] {
+		return nil
+	}
+	var missing []string
+	for _, c := range required {
+		if !granted[c] {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// checkCapabilities writes to out the result of each check and returns an
+// error if any required capability is missing.
+func checkCapabilities(out io.Writer, client *api.Client, checks []capabilityCheck) error {
+	paths := make([]string, 0, len(checks))
+	for _, c := range checks {
+		paths = append(paths, c.path)
+	}
+	capabilities, err := capabilitiesSelf(client, paths)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Result\tPath\tRequired\tCapabilities")
+	fmt.Fprintln(tw, "------\t----\t--------\t------------")
+	for _, c := range checks {
+		result := "ok"
+		if len(missingCapabilities(capabilities[c.path], c.required)) > 0 {
+			result = "missing"
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result, c.path, strings.Join(c.required, ","), strings.Join(capabilities[c.path], ","))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d paths miss required capabilities", failed, len(checks))
+	}
+	return nil
+}
+
+// writeCapabilities writes to out the capabilities on each path and returns
+// an error if any path is denied.
+func writeCapabilities(out io.Writer, client *api.Client, paths []string) error {
+	capabilities, err := capabilitiesSelf(client, paths)
+	if err != nil {
+		return err
+	}
+	denied := 0
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Path\tCapabilities")
+	fmt.Fprintln(tw, "----\t------------")
+	for _, path := range paths {
+		if strings.Join(capabilities[path], ",") == "deny" {
+			denied++
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", path, strings.Join(capabilities[path], ","))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if denied > 0 {
+		return fmt.Errorf("%d of %d paths are denied", denied, len(paths))
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(canCmd)
+	rootCmd.AddCommand(capabilitiesCmd)
+	canCmd.Flags().String("from-file", "", `File listing one check per line, "[capability[,capability...]] path",
+"-" for the standard input`)
+	capabilitiesCmd.Flags().String("from-file", "", `File listing one path per line, "-" for the standard input`)
+}
+
+var canCmd = &cobra.Command{
+	Use:   "can <capability> <path>... | can [capability] --from-file FILE",
+	Short: "Check the capabilities of the stored token on paths",
+	Long: `This subcommand checks, with a single sys/capabilities-self request, that the
+stored token has the capability on every path and exits with a non-zero status
+if any is missing, e.g. as the pre-flight check of a deploy:
+
+    $ vauth can update secret/data/app aws/creds/deploy
+    $ vauth can read --from-file checks.txt
+
+The --from-file list has one path per line, optionally preceded by the comma
+separated capabilities required on it; the paths alone require the capability
+argument:
+
+    # checks.txt
+    read,list  secret/metadata/app
+    update     aws/creds/deploy
+    secret/data/app
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if fromFile, _ := cmd.Flags().GetString("from-file"); fromFile != "" {
+			return nil
+		}
+		return cobra.MinimumNArgs(2)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fromFile, err := cmd.Flags().GetString("from-file")
+		if err != nil {
+			return err
+		}
+		checks, err := canChecks(args, fromFile)
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := sessionClient(ctx)
+		if err != nil {
+			return err
+		}
+		return checkCapabilities(os.Stdout, client, checks)
+	},
+}
+
+var capabilitiesCmd = &cobra.Command{
+	Use:   "capabilities <path>... | capabilities --from-file FILE",
+	Short: "Print the capabilities of the stored token on paths",
+	Long: `This subcommand prints the capabilities of the stored token on every path, read
+with a single sys/capabilities-self request, and exits with a non-zero status if
+any path is denied:
+
+    $ vauth capabilities secret/data/app aws/creds/deploy
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if fromFile, _ := cmd.Flags().GetString("from-file"); fromFile != "" {
+			return nil
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fromFile, err := cmd.Flags().GetString("from-file")
+		if err != nil {
+			return err
+		}
+		paths := args
+		if fromFile != "" {
+			checks, err := readCapabilityFile(fromFile, nil)
+			if err != nil {
+				return err
+			}
+			for _, c := range checks {
+				paths = append(paths, c.path)
+			}
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no path to check")
+		}
+		cmd.SilenceUsage = true
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := sessionClient(ctx)
+		if err != nil {
+			return err
+		}
+		return writeCapabilities(os.Stdout, client, paths)
+	},
+}
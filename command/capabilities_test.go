@@ -0,0 +1,218 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestReadCapabilityChecks(t *testing.T) {
+	readTests := []struct {
+		name     string
+		in       string
+		defaults []string
+		want     []capabilityCheck
+		wantErr  string
+	}{
+		{
+			name:     "paths and capabilities",
+			in:       "# pre-flight\nread,list  secret/metadata/app\n\nsecret/data/app\n",
+			defaults: []string{"read"},
+			want: []capabilityCheck{
+				{path: "secret/metadata/app", required: []string{"read", "list"}},
+				{path: "secret/data/app", required: []string{"read"}},
+			},
+		},
+		{name: "invalid line", in: "read secret/app extra\n", wantErr: "line 1: expected"},
+	}
+	for _, tt := range readTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCapabilityChecks(strings.NewReader(tt.in), tt.defaults)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vauth-can")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checksFile := filepath.Join(dir, "checks.txt")
+	if err := ioutil.WriteFile(checksFile, []byte("read,list secret/metadata/app\nsecret/data/app\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	explicitFile := filepath.Join(dir, "explicit.txt")
+	if err := ioutil.WriteFile(explicitFile, []byte("update aws/creds/deploy\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	canTests := []struct {
+		name     string
+		args     []string
+		fromFile string
+		want     []capabilityCheck
+		wantErr  string
+	}{
+		{
+			name: "arguments",
+			args: []string{"update", "aws/creds/deploy"},
+			want: []capabilityCheck{{path: "aws/creds/deploy", required: []string{"update"}}},
+		},
+		{
+			name:     "file only",
+			fromFile: explicitFile,
+			want:     []capabilityCheck{{path: "aws/creds/deploy", required: []string{"update"}}},
+		},
+		{
+			name:     "file with the capability argument",
+			args:     []string{"read"},
+			fromFile: checksFile,
+			want: []capabilityCheck{
+				{path: "secret/metadata/app", required: []string{"read", "list"}},
+				{path: "secret/data/app", required: []string{"read"}},
+			},
+		},
+		{name: "file only without capability", fromFile: checksFile, wantErr: "no capability required on secret/data/app"},
+		{name: "no path", args: []string{"read"}, wantErr: "no path to check"},
+	}
+	for _, tt := range canTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := canChecks(tt.args, tt.fromFile)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.SetCapabilities("deploy", "secret/data/app", "read")
+	vault.SetCapabilities("deploy", "aws/creds/*", "read", "update")
+	token := vault.CreateToken("deploy")
+	client, err := vault.Client(token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkTests := []struct {
+		name    string
+		root    bool
+		checks  []capabilityCheck
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "granted",
+			checks: []capabilityCheck{
+				{path: "secret/data/app", required: []string{"read"}},
+				{path: "aws/creds/deploy", required: []string{"read", "update"}},
+			},
+			want: []string{"ok      secret/data/app   read         read", "ok      aws/creds/deploy  read,update  read,update"},
+		},
+		{
+			name: "missing",
+			checks: []capabilityCheck{
+				{path: "secret/data/app", required: []string{"update"}},
+				{path: "secret/data/other", required: []string{"read"}},
+				{path: "aws/creds/deploy", required: []string{"update"}},
+			},
+			want:    []string{"missing  secret/data/app", "missing  secret/data/other  read      deny", "ok       aws/creds/deploy"},
+			wantErr: "2 of 3 paths miss required capabilities",
+		},
+		{
+			name: "duplicate paths",
+			checks: []capabilityCheck{
+				{path: "secret/data/app", required: []string{"read"}},
+				{path: "secret/data/app", required: []string{"update"}},
+			},
+			want:    []string{"ok       secret/data/app  read      read\n", "missing  secret/data/app  update    read\n"},
+			wantErr: "1 of 2 paths miss required capabilities",
+		},
+		{
+			name:   "root",
+			root:   true,
+			checks: []capabilityCheck{{path: "sys/policies/acl/admin", required: []string{"sudo", "update"}}},
+			want:   []string{"ok      sys/policies/acl/admin  sudo,update  root"},
+		},
+	}
+	for _, tt := range checkTests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := client
+			if tt.root {
+				c = root
+			}
+			var out bytes.Buffer
+			err := checkCapabilities(&out, c, tt.checks)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Fatalf("got %q, want %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteCapabilities(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.SetCapabilities("deploy", "secret/data/app", "read", "list")
+	token := vault.CreateToken("deploy")
+	client, err := vault.Client(token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := writeCapabilities(&out, client, []string{"secret/data/app"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "secret/data/app  list,read") {
+		t.Fatalf("got %q, want the capabilities of secret/data/app", out.String())
+	}
+	out.Reset()
+	err = writeCapabilities(&out, client, []string{"secret/data/app", "secret/data/other"})
+	if err == nil || err.Error() != "1 of 2 paths are denied" {
+		t.Fatalf("got %v, want 1 of 2 paths are denied", err)
+	}
+}
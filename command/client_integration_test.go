@@ -0,0 +1,62 @@
+//go:build integration
+// +build integration
+
+package command
+
+import (
+	"context"
+	"fmt"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+	"strings"
+	"testing"
+)
+
+func TestClientBadTokenIntegration(t *testing.T) {
+	token := "s6gjRs4pYBO4pyDGyp73e8Zmt"
+	ctx := context.Background()
+	req := testcontainers.ContainerRequest{
+		Image:        "vault",
+		ExposedPorts: []string{"8200/tcp"},
+		Cmd:          "server -dev",
+		Env: map[string]string{
+			"VAULT_DEV_ROOT_TOKEN_ID":  token,
+			"VAULT_DEV_LISTEN_ADDRESS": "0.0.0.0:8200",
+		},
+	}
+	vaultC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	// At the end of the test remove the container
+	defer vaultC.Terminate(ctx)
+	// Retrieve the container IP
+	ip, err := vaultC.Host(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+	// Retrieve the port mapped to port 8200
+	port, err := vaultC.MappedPort(ctx, "8200")
+	if err != nil {
+		t.Error(err)
+	}
+
+	address := fmt.Sprintf("http://%s:%s", ip, port.Port())
+	client, _ := NewClient(nil)
+	client.SetAddress(address)
+	client.SetToken(token)
+
+	client.SetToken(token)
+	_, err = client.RawRequest(client.NewRequest("PUT", "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.SetToken("foo\u007f")
+	_, err = client.RawRequest(client.NewRequest("PUT", "/"))
+	if err == nil || !strings.Contains(err.Error(), "printable") {
+		t.Fatalf("expected error due to bad token")
+	}
+}
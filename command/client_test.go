@@ -1,10 +1,8 @@
 package command
 
 import (
-	"context"
-	"fmt"
 	"github.com/hashicorp/vault/api"
-	testcontainers "github.com/testcontainers/testcontainers-go"
+	"github.com/mauromedda/vauth/internal/vaulttest"
 	"os"
 	"strings"
 	"testing"
@@ -86,44 +84,14 @@ func TestClientToken(t *testing.T) {
 }
 
 func TestClientBadToken(t *testing.T) {
-	token := "s6gjRs4pYBO4pyDGyp73e8Zmt"
-	ctx := context.Background()
-	req := testcontainers.ContainerRequest{
-		Image:        "vault",
-		ExposedPorts: []string{"8200/tcp"},
-		Cmd:          "server -dev",
-		Env: map[string]string{
-			"VAULT_DEV_ROOT_TOKEN_ID":  token,
-			"VAULT_DEV_LISTEN_ADDRESS": "0.0.0.0:8200",
-		},
-	}
-	vaultC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-		ContainerRequest: req,
-		Started:          true,
-	})
-	if err != nil {
-		t.Error(err)
-	}
-	// At the end of the test remove the container
-	defer vaultC.Terminate(ctx)
-	// Retrieve the container IP
-	ip, err := vaultC.Host(ctx)
-	if err != nil {
-		t.Error(err)
-	}
-	// Retrieve the port mapped to port 8200
-	port, err := vaultC.MappedPort(ctx, "8200")
-	if err != nil {
-		t.Error(err)
-	}
+	vault := vaulttest.NewServer()
+	defer vault.Close()
 
-	address := fmt.Sprintf("http://%s:%s", ip, port.Port())
 	client, _ := NewClient(nil)
-	client.SetAddress(address)
-	client.SetToken(token)
+	client.SetAddress(vault.URL)
 
-	client.SetToken(token)
-	_, err = client.RawRequest(client.NewRequest("PUT", "/"))
+	client.SetToken(vault.RootToken)
+	_, err := client.RawRequest(client.NewRequest("GET", "/v1/auth/token/lookup-self"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,225 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// dockerHelperName is the name of the binary docker runs as credential
+// helper: vauth symlinked as docker-credential-vauth.
+const dockerHelperName = "docker-credential-vauth"
+
+// errDockerCredentialsNotFound is the error, with the message the docker
+// credential helper protocol expects, of a registry without credentials.
+var errDockerCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// dockerCredentials are the credentials of a registry exchanged with docker.
+type dockerCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// dockerRegistry returns the registry hostname of a docker server URL, e.g.
+// index.docker.io for https://index.docker.io/v1/.
+func dockerRegistry(serverURL string) (string, error) {
+	serverURL = strings.TrimSpace(serverURL)
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "https://" + serverURL
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid registry %q", serverURL)
+	}
+	return u.Host, nil
+}
+
+// dockerPath returns the Vault path of the credentials of the registry of
+// serverURL, rendering the path template with the .Host field.
+func dockerPath(pathTemplate, serverURL string) (string, error) {
+	host, err := dockerRegistry(serverURL)
+	if err != nil {
+		return "", err
+	}
+	return renderPath(pathTemplate, struct{ Host string }{Host: host})
+}
+
+// dockerCredentialHelper implements the docker credential helper protocol
+// storing the credentials in Vault KV at pathTemplate.
+type dockerCredentialHelper struct {
+	client       *api.Client
+	pathTemplate string
+}
+
+// get returns the credentials of the registry of serverURL.
+func (h *dockerCredentialHelper) get(serverURL string) (*dockerCredentials, error) {
+	p, err := dockerPath(h.pathTemplate, serverURL)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := kvRead(h.client, p)
+	if err != nil {
+		return nil, err
+	}
+	password, ok := secretField(secret, "password")
+	if !ok {
+		return nil, errDockerCredentialsNotFound
+	}
+	creds := &dockerCredentials{ServerURL: serverURL, Secret: password}
+	creds.Username, _ = secretField(secret, "username")
+	if u, ok := secretField(secret, "server_url"); ok {
+		creds.ServerURL = u
+	}
+	return creds, nil
+}
+
+// store saves the credentials of a registry.
+func (h *dockerCredentialHelper) store(creds *dockerCredentials) error {
+	p, err := dockerPath(h.pathTemplate, creds.ServerURL)
+	if err != nil {
+		return err
+	}
+	return kvWrite(h.client, p, map[string]interface{}{
+		"server_url": creds.ServerURL,
+		"username":   creds.Username,
+		"password":   creds.Secret,
+	})
+}
+
+// erase deletes the credentials of the registry of serverURL.
+func (h *dockerCredentialHelper) erase(serverURL string) error {
+	p, err := dockerPath(h.pathTemplate, serverURL)
+	if err != nil {
+		return err
+	}
+	return kvDelete(h.client, p)
+}
+
+// list returns the registries with stored credentials and their username.
+// It requires the host to be the last element of the path template.
+func (h *dockerCredentialHelper) list() (map[string]string, error) {
+	dir, last := path.Split(h.pathTemplate)
+	if last != "{{.Host}}" {
+		return nil, fmt.Errorf("list requires a path template ending with {{.Host}}")
+	}
+	secret, err := kvList(h.client, dir)
+	if err != nil {
+		return nil, err
+	}
+	registries := make(map[string]string)
+	if secret == nil {
+		return registries, nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, k := range keys {
+		host, _ := k.(string)
+		if host == "" || strings.HasSuffix(host, "/") {
+			continue
+		}
+		creds, err := h.get(host)
+		if err != nil {
+			continue
+		}
+		registries[creds.ServerURL] = creds.Username
+	}
+	return registries, nil
+}
+
+// run executes the action of the protocol reading the request from in and
+// writing the response to out.
+func (h *dockerCredentialHelper) run(action string, in io.Reader, out io.Writer) error {
+	switch action {
+	case "get":
+		serverURL, err := ioutil.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		creds, err := h.get(strings.TrimSpace(string(serverURL)))
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(out).Encode(creds)
+	case "store":
+		var creds dockerCredentials
+		if err := json.NewDecoder(in).Decode(&creds); err != nil {
+			return fmt.Errorf("error decoding the credentials: %s", err)
+		}
+		return h.store(&creds)
+	case "erase":
+		serverURL, err := ioutil.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		return h.erase(strings.TrimSpace(string(serverURL)))
+	case "list":
+		registries, err := h.list()
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(out).Encode(registries)
+	}
+	return fmt.Errorf("%s action not supported", action)
+}
+
+func init() {
+	rootCmd.AddCommand(dockerCredentialCmd)
+	helperCommands[dockerHelperName] = dockerCredentialCmd
+	pathTemplate := os.Getenv("VAUTH_DOCKER_PATH")
+	if pathTemplate == "" {
+		pathTemplate = "secret/docker/{{.Host}}"
+	}
+	dockerCredentialCmd.Flags().String("path-template", pathTemplate, "Vault KV path of the registry credentials, {{.Host}} is the registry hostname")
+}
+
+var dockerCredentialCmd = &cobra.Command{
+	Use:   "docker-credential <get|store|erase|list>",
+	Short: "Docker credential helper backed by Vault KV",
+	Long: `This subcommand implements the docker credential helper protocol storing the
+registry credentials (username and password fields) in Vault KV, at the path
+rendered from --path-template or $VAUTH_DOCKER_PATH. The path is the one of
+vauth kv get, e.g. secret/docker/ghcr.io: the KV version of the mount is
+detected. Vault is accessed with the stored token.
+
+Symlink vauth as docker-credential-vauth in the $PATH and configure it in
+~/.docker/config.json:
+
+    { "credsStore": "vauth" }
+`,
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := runDockerCredential(cmd, args[0])
+		if err != nil {
+			// The protocol expects the errors on stdout
+			fmt.Fprintln(os.Stdout, err)
+		}
+		return err
+	},
+}
+
+// runDockerCredential runs the docker credential helper action.
+func runDockerCredential(cmd *cobra.Command, action string) error {
+	pathTemplate, err := cmd.Flags().GetString("path-template")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext(cmd)
+	defer cancel()
+	client, err := sessionClient(ctx)
+	if err != nil {
+		return err
+	}
+	h := &dockerCredentialHelper{client: client, pathTemplate: pathTemplate}
+	return h.run(action, os.Stdin, os.Stdout)
+}
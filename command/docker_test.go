@@ -0,0 +1,110 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestDockerPath(t *testing.T) {
+	tests := []struct {
+		serverURL string
+		want      string
+	}{
+		{"https://index.docker.io/v1/", "secret/docker/index.docker.io"},
+		{"registry.example.com:5000", "secret/docker/registry.example.com:5000"},
+		{" ghcr.io\n", "secret/docker/ghcr.io"},
+	}
+	for _, tt := range tests {
+		got, err := dockerPath("secret/docker/{{.Host}}", tt.serverURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Fatalf("got %v, want %v", got, tt.want)
+		}
+	}
+	if _, err := dockerPath("secret/{{.Missing}}", "ghcr.io"); err == nil {
+		t.Fatal("expected an error with an invalid template field")
+	}
+}
+
+func TestDockerCredentialHelper(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.EnableKV("secret", 2)
+	vault.EnableKV("kv", 1)
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helperTests := []struct {
+		name         string
+		pathTemplate string
+		mount        string
+		path         string
+	}{
+		{name: "kv v2", pathTemplate: "secret/docker/{{.Host}}", mount: "secret", path: "docker/ghcr.io"},
+		{name: "kv v2 data path", pathTemplate: "secret/data/docker/{{.Host}}", mount: "secret", path: "docker/ghcr.io"},
+		// A KV v1 path is never taken for a KV v2 data path
+		{name: "kv v1", pathTemplate: "kv/data/{{.Host}}", mount: "kv", path: "data/ghcr.io"},
+	}
+	for _, tt := range helperTests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &dockerCredentialHelper{client: client, pathTemplate: tt.pathTemplate}
+
+			var out bytes.Buffer
+			err = h.run("get", strings.NewReader("ghcr.io\n"), &out)
+			if err != errDockerCredentialsNotFound {
+				t.Fatalf("got %v, want %v", err, errDockerCredentialsNotFound)
+			}
+
+			in := `{"ServerURL":"https://ghcr.io","Username":"bot","Secret":"s3cr3t"}`
+			if err := h.run("store", strings.NewReader(in), &out); err != nil {
+				t.Fatal(err)
+			}
+			data, ok := vault.Secret(tt.mount, tt.path)
+			if !ok || data["password"] != "s3cr3t" {
+				t.Fatalf("unexpected stored secret %v", data)
+			}
+
+			out.Reset()
+			if err := h.run("get", strings.NewReader("ghcr.io"), &out); err != nil {
+				t.Fatal(err)
+			}
+			var creds dockerCredentials
+			if err := json.Unmarshal(out.Bytes(), &creds); err != nil {
+				t.Fatal(err)
+			}
+			if creds.Username != "bot" || creds.Secret != "s3cr3t" {
+				t.Fatalf("unexpected credentials %+v", creds)
+			}
+
+			out.Reset()
+			if err := h.run("list", nil, &out); err != nil {
+				t.Fatal(err)
+			}
+			var registries map[string]string
+			if err := json.Unmarshal(out.Bytes(), &registries); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := registries["https://ghcr.io"], "bot"; got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+
+			if err := h.run("erase", strings.NewReader("ghcr.io"), &out); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := vault.Secret(tt.mount, tt.path); ok {
+				t.Fatal("expected the credentials to be erased")
+			}
+			if err := h.run("unknown", nil, &out); err == nil {
+				t.Fatal("expected an error with an unknown action")
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// envVar is an environment variable exported by the env sub-command.
+type envVar struct {
+	name  string
+	value string
+}
+
+// envVars returns the Vault environment variables of the current session:
+// VAULT_ADDR, VAULT_TOKEN and the namespace and TLS settings, if set.
+func envVars(address, token string) []envVar {
+	vars := []envVar{
+		{name: api.EnvVaultAddress, value: address},
+		{name: api.EnvVaultToken, value: token},
+	}
+	for _, name := range []string{
+		api.EnvVaultNamespace,
+		api.EnvVaultCACert,
+		api.EnvVaultCAPath,
+		api.EnvVaultClientCert,
+		api.EnvVaultClientKey,
+		api.EnvVaultTLSServerName,
+		api.EnvVaultSkipVerify,
+	} {
+		if value := os.Getenv(name); value != "" {
+			vars = append(vars, envVar{name: name, value: value})
+		}
+	}
+	return vars
+}
+
+// envShells maps the supported --shell values to the function formatting
+// the export statement of a variable.
+var envShells = map[string]func(name, value string) string{
+	"bash": posixExport,
+	"zsh":  posixExport,
+	"fish": func(name, value string) string {
+		value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+		return fmt.Sprintf("set -gx %s '%s';", name, value)
+	},
+	"powershell": func(name, value string) string {
+		return fmt.Sprintf("$Env:%s = '%s'", name, strings.Replace(value, "'", "''", -1))
+	},
+	"dotenv": func(name, value string) string {
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		return fmt.Sprintf(`%s="%s"`, name, value)
+	},
+	// GitLab dotenv reports do not support quoting
+	"gitlab": func(name, value string) string {
+		return fmt.Sprintf("%s=%s", name, value)
+	},
+	"github-actions": func(name, value string) string {
+		return fmt.Sprintf("%s=%s", name, value)
+	},
+}
+
+// posixExport formats an export statement for POSIX shells.
+func posixExport(name, value string) string {
+	return fmt.Sprintf("export %s='%s'", name, strings.Replace(value, "'", `'\''`, -1))
+}
+
+// writeEnv writes the export statements of vars for shell to out.
+func writeEnv(out io.Writer, shell string, vars []envVar) error {
+	format, ok := envShells[shell]
+	if !ok {
+		return fmt.Errorf("%s shell not supported", shell)
+	}
+	for _, v := range vars {
+		if _, err := fmt.Fprintln(out, format(v.name, v.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeGitHubEnv masks the token in the GitHub Actions logs, printing the
+// add-mask workflow command to out, and appends vars to the $GITHUB_ENV file.
+func writeGitHubEnv(out io.Writer, vars []envVar) error {
+	path := os.Getenv("GITHUB_ENV")
+	if path == "" {
+		return fmt.Errorf("GITHUB_ENV not set, the github-actions shell works only inside GitHub Actions")
+	}
+	for _, v := range vars {
+		if v.name == api.EnvVaultToken {
+			fmt.Fprintf(out, "::add-mask::%s\n", v.value)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeEnv(f, "github-actions", vars); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+	envCmd.Flags().StringP("shell", "s", "bash", "Syntax of the statements: bash, zsh, fish, powershell, dotenv, github-actions or gitlab")
+	addLoginFlags(envCmd)
+}
+
+var envCmd = &cobra.Command{
+	Use:   "env [-m method [K=V...]]",
+	Short: "Print the shell export statements for the current session",
+	Long: `This subcommand prints the statements exporting VAULT_ADDR, VAULT_TOKEN,
+VAULT_NAMESPACE and the TLS settings of the current session, e.g.
+
+    $ eval "$(vauth env)"
+
+The token is the one stored by the token helper, unless a method is provided:
+then a fresh login is performed with the same parameters of the login sub-command.
+
+With --shell github-actions the variables are appended to the $GITHUB_ENV file
+and the token is masked in the workflow logs.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		shell, err := cmd.Flags().GetString("shell")
+		if err != nil {
+			return err
+		}
+		if _, ok := envShells[shell]; !ok {
+			return fmt.Errorf("%s shell not supported", shell)
+		}
+		cmd.SilenceUsage = true
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		token, err := sessionToken(ctx, cmd, args)
+		if err != nil {
+			return err
+		}
+		config := api.DefaultConfig()
+		if config.Error != nil {
+			return config.Error
+		}
+
+		vars := envVars(config.Address, token)
+		if shell == "github-actions" {
+			return writeGitHubEnv(os.Stdout, vars)
+		}
+		return writeEnv(os.Stdout, shell, vars)
+	},
+}
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+	"github.com/spf13/cobra"
+)
+
+func TestWriteEnv(t *testing.T) {
+	vars := []envVar{
+		{name: "VAULT_ADDR", value: "https://vault:8200"},
+		{name: "VAULT_TOKEN", value: `s.it's"a\token`},
+	}
+	envTests := []struct {
+		shell string
+		want  string
+	}{
+		{shell: "bash", want: "export VAULT_ADDR='https://vault:8200'\nexport VAULT_TOKEN='s.it'\\''s\"a\\token'\n"},
+		{shell: "zsh", want: "export VAULT_ADDR='https://vault:8200'\nexport VAULT_TOKEN='s.it'\\''s\"a\\token'\n"},
+		{shell: "fish", want: "set -gx VAULT_ADDR 'https://vault:8200';\nset -gx VAULT_TOKEN 's.it\\'s\"a\\\\token';\n"},
+		{shell: "powershell", want: "$Env:VAULT_ADDR = 'https://vault:8200'\n$Env:VAULT_TOKEN = 's.it''s\"a\\token'\n"},
+		{shell: "dotenv", want: "VAULT_ADDR=\"https://vault:8200\"\nVAULT_TOKEN=\"s.it's\\\"a\\\\token\"\n"},
+		{shell: "gitlab", want: "VAULT_ADDR=https://vault:8200\nVAULT_TOKEN=s.it's\"a\\token\n"},
+	}
+	for _, tt := range envTests {
+		t.Run(tt.shell, func(t *testing.T) {
+			got := &bytes.Buffer{}
+			if err := writeEnv(got, tt.shell, vars); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+
+	if err := writeEnv(&bytes.Buffer{}, "cmd", vars); err == nil {
+		t.Error("expected an error for an unsupported shell")
+	}
+}
+
+func TestWriteGitHubEnv(t *testing.T) {
+	f, err := ioutil.TempFile("", "github_env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	os.Setenv("GITHUB_ENV", f.Name())
+	defer os.Unsetenv("GITHUB_ENV")
+
+	vars := []envVar{
+		{name: "VAULT_ADDR", value: "https://vault:8200"},
+		{name: "VAULT_TOKEN", value: "s.secret"},
+	}
+	out := &bytes.Buffer{}
+	if err := writeGitHubEnv(out, vars); err != nil {
+		t.Fatal(err)
+	}
+	if want := "::add-mask::s.secret\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "VAULT_ADDR=https://vault:8200\nVAULT_TOKEN=s.secret\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEnvVars(t *testing.T) {
+	os.Setenv("VAULT_NAMESPACE", "team")
+	defer os.Unsetenv("VAULT_NAMESPACE")
+
+	vars := envVars("https://vault:8200", "s.token")
+	want := []envVar{
+		{name: "VAULT_ADDR", value: "https://vault:8200"},
+		{name: "VAULT_TOKEN", value: "s.token"},
+		{name: "VAULT_NAMESPACE", value: "team"},
+	}
+	if len(vars) != len(want) {
+		t.Fatalf("got %v, want %v", vars, want)
+	}
+	for i := range want {
+		if vars[i] != want[i] {
+			t.Errorf("got %v, want %v", vars[i], want[i])
+		}
+	}
+}
+
+func TestSessionTokenFreshLogin(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddUser("userpass", "test", "test")
+	os.Setenv("VAULT_ADDR", vault.URL)
+	defer os.Setenv("VAULT_ADDR", "")
+
+	cmd := &cobra.Command{}
+	addLoginFlags(cmd)
+	cmd.Flags().Set("method", "userpass")
+	token, err := sessionToken(context.Background(), cmd, []string{"username=test", "password=test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vault.LookupToken(token); !ok {
+		t.Fatalf("expected a token issued by the server, got %q", token)
+	}
+}
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	vt "github.com/mauromedda/vauth/command/token"
+)
+
+// writeFileAtomic writes data to path with the given permissions through a
+// temporary file renamed over path, so that readers never see a partially
+// written file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	sink := &vt.FileSink{Path: path, Mode: mode}
+	return sink.Store(string(data))
+}
+
+// writeTempFile writes data with the given permissions to a new temporary
+// file in dir, named after name, and returns its path.
+func writeTempFile(dir, name string, data []byte, mode os.FileMode) (string, error) {
+	f, err := ioutil.TempFile(dir, "."+name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// runReload runs the reload command, if any, through the shell after the
+// files it depends on changed.
+func runReload(ctx context.Context, command string, stdout, stderr io.Writer) error {
+	if command == "" {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running the reload command: %s", err)
+	}
+	return nil
+}
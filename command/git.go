@@ -0,0 +1,211 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// gitHelperName is the name of the binary git runs for the credential.helper
+// vauth: vauth symlinked as git-credential-vauth.
+const gitHelperName = "git-credential-vauth"
+
+// gitCredential is a credential description of the git credential helper
+// protocol: the protocol, host, path, username and password attributes.
+type gitCredential map[string]string
+
+// readGitCredential reads the key=value attributes sent by git until a blank
+// line or EOF.
+func readGitCredential(r io.Reader) (gitCredential, error) {
+	cred := make(gitCredential)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid credential attribute %q", line)
+		}
+		cred[kv[0]] = kv[1]
+	}
+	return cred, scanner.Err()
+}
+
+// write writes the attributes to w in the format expected by git.
+func (c gitCredential) write(w io.Writer) error {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", k, c[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitPath returns the Vault path of the credential rendering the path
+// template with the .Protocol, .Host and .Path fields.
+func gitPath(pathTemplate string, cred gitCredential) (string, error) {
+	if cred["host"] == "" {
+		return "", fmt.Errorf("missing host attribute")
+	}
+	return renderPath(pathTemplate, struct{ Protocol, Host, Path string }{
+		Protocol: cred["protocol"],
+		Host:     cred["host"],
+		Path:     strings.Trim(cred["path"], "/"),
+	})
+}
+
+// gitCredentialHelper implements the git credential helper protocol reading
+// the credentials from Vault KV at pathTemplate. store and erase update KV
+// only when write is set.
+type gitCredentialHelper struct {
+	client       *api.Client
+	pathTemplate string
+	write        bool
+}
+
+// get returns the username and password of the credential, none when Vault
+// has no credential for it so that git falls back to the next helper.
+func (h *gitCredentialHelper) get(cred gitCredential) (gitCredential, error) {
+	p, err := gitPath(h.pathTemplate, cred)
+	if err != nil {
+		return nil, err
+	}
+	// Fail fast instead of letting git prompt after a permission denied
+	if _, err := h.client.Auth().Token().LookupSelf(); err != nil {
+		return nil, fmt.Errorf("the stored Vault token is not valid, run \"vauth login\": %s", err)
+	}
+	secret, err := kvRead(h.client, p)
+	if err != nil {
+		return nil, err
+	}
+	password, ok := secretField(secret, "password")
+	if !ok {
+		return gitCredential{}, nil
+	}
+	found := gitCredential{"password": password}
+	if username, ok := secretField(secret, "username"); ok {
+		found["username"] = username
+	} else if cred["username"] != "" {
+		found["username"] = cred["username"]
+	}
+	return found, nil
+}
+
+// store saves the credential approved by git.
+func (h *gitCredentialHelper) store(cred gitCredential) error {
+	if !h.write {
+		return nil
+	}
+	p, err := gitPath(h.pathTemplate, cred)
+	if err != nil {
+		return err
+	}
+	return kvWrite(h.client, p, map[string]interface{}{
+		"username": cred["username"],
+		"password": cred["password"],
+	})
+}
+
+// erase deletes the credential rejected by git.
+func (h *gitCredentialHelper) erase(cred gitCredential) error {
+	if !h.write {
+		return nil
+	}
+	p, err := gitPath(h.pathTemplate, cred)
+	if err != nil {
+		return err
+	}
+	return kvDelete(h.client, p)
+}
+
+// run executes the operation of the protocol reading the credential from in
+// and writing the response to out. Unknown operations are ignored as
+// required by the protocol.
+func (h *gitCredentialHelper) run(operation string, in io.Reader, out io.Writer) error {
+	cred, err := readGitCredential(in)
+	if err != nil {
+		return err
+	}
+	switch operation {
+	case "get":
+		found, err := h.get(cred)
+		if err != nil {
+			return err
+		}
+		return found.write(out)
+	case "store":
+		return h.store(cred)
+	case "erase":
+		return h.erase(cred)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(gitCredentialCmd)
+	helperCommands[gitHelperName] = gitCredentialCmd
+	pathTemplate := os.Getenv("VAUTH_GIT_PATH")
+	if pathTemplate == "" {
+		pathTemplate = "secret/git/{{.Host}}{{with .Path}}/{{.}}{{end}}"
+	}
+	gitCredentialCmd.Flags().String("path-template", pathTemplate, "Vault KV path of the credentials, {{.Host}} and {{.Path}} are the git host and repository path")
+	gitCredentialCmd.Flags().Bool("write", false, "Write back to Vault KV the credentials stored and erased by git")
+}
+
+var gitCredentialCmd = &cobra.Command{
+	Use:   "git-credential <get|store|erase>",
+	Short: "Git credential helper backed by Vault KV",
+	Long: `This subcommand implements the git credential helper protocol reading the
+HTTPS credentials (username and password fields) from Vault KV, at the path
+rendered from --path-template or $VAUTH_GIT_PATH. The path is the one of vauth
+kv get, e.g. secret/git/github.com: the KV version of the mount is detected.
+Vault is accessed with the stored token. The credentials stored and erased by
+git are written back to Vault only with --write.
+
+Configure it with:
+
+    $ git config --global credential.helper "!vauth git-credential"
+
+or symlink vauth as git-credential-vauth in the $PATH and use:
+
+    $ git config --global credential.helper vauth
+
+The repository path is sent by git only with credential.useHttpPath set.
+`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pathTemplate, err := cmd.Flags().GetString("path-template")
+		if err != nil {
+			return err
+		}
+		write, err := cmd.Flags().GetBool("write")
+		if err != nil {
+			return err
+		}
+		if args[0] != "get" && !write {
+			return nil
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := sessionClient(ctx)
+		if err != nil {
+			return err
+		}
+		h := &gitCredentialHelper{client: client, pathTemplate: pathTemplate, write: write}
+		return h.run(args[0], os.Stdin, os.Stdout)
+	},
+}
@@ -0,0 +1,128 @@
+package command
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestReadGitCredential(t *testing.T) {
+	in := "protocol=https\r\nhost=github.com\npath=org/repo.git\n\nignored=true\n"
+	cred, err := readGitCredential(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := gitCredential{"protocol": "https", "host": "github.com", "path": "org/repo.git"}
+	if len(cred) != len(want) {
+		t.Fatalf("got %v, want %v", cred, want)
+	}
+	for k, v := range want {
+		if cred[k] != v {
+			t.Fatalf("got %v, want %v", cred, want)
+		}
+	}
+	if _, err := readGitCredential(strings.NewReader("host\n")); err == nil {
+		t.Fatal("expected an error with an invalid attribute")
+	}
+}
+
+func TestGitPath(t *testing.T) {
+	const pathTemplate = "secret/git/{{.Host}}{{with .Path}}/{{.}}{{end}}"
+	tests := []struct {
+		cred gitCredential
+		want string
+	}{
+		{gitCredential{"host": "github.com"}, "secret/git/github.com"},
+		{gitCredential{"host": "github.com", "path": "org/repo.git"}, "secret/git/github.com/org/repo.git"},
+	}
+	for _, tt := range tests {
+		got, err := gitPath(pathTemplate, tt.cred)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Fatalf("got %v, want %v", got, tt.want)
+		}
+	}
+	if _, err := gitPath(pathTemplate, gitCredential{}); err == nil {
+		t.Fatal("expected an error without host")
+	}
+}
+
+func TestGitCredentialHelper(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.EnableKV("secret", 2)
+	vault.SetSecret("secret", "git/github.com", map[string]interface{}{"username": "bot", "password": "s3cr3t"})
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &gitCredentialHelper{client: client, pathTemplate: "secret/git/{{.Host}}"}
+
+	var out bytes.Buffer
+	if err := h.run("get", strings.NewReader("protocol=https\nhost=github.com\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "password=s3cr3t\nusername=bot\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Missing credentials: no output to let git prompt
+	out.Reset()
+	if err := h.run("get", strings.NewReader("host=gitlab.com\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("got %q, want no output", out.String())
+	}
+
+	// store and erase are ignored without write
+	in := "protocol=https\nhost=gitlab.com\nusername=ci\npassword=token\n"
+	if err := h.run("store", strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vault.Secret("secret", "git/gitlab.com"); ok {
+		t.Fatal("expected store to be ignored without write")
+	}
+
+	h.write = true
+	if err := h.run("store", strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	data, ok := vault.Secret("secret", "git/gitlab.com")
+	if !ok || data["username"] != "ci" || data["password"] != "token" {
+		t.Fatalf("unexpected stored secret %v", data)
+	}
+	if err := h.run("erase", strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vault.Secret("secret", "git/gitlab.com"); ok {
+		t.Fatal("expected the credentials to be erased")
+	}
+
+	// KV v1 mounts are written unwrapped, even at a data/ path
+	vault.EnableKV("kv", 1)
+	h.pathTemplate = "kv/data/git/{{.Host}}"
+	if err := h.run("store", strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := vault.Secret("kv", "data/git/gitlab.com"); !ok || data["password"] != "token" {
+		t.Fatalf("unexpected stored secret %v", data)
+	}
+	out.Reset()
+	if err := h.run("get", strings.NewReader("host=gitlab.com\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "password=token\nusername=ci\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	client.SetToken("invalid")
+	err = h.run("get", strings.NewReader("host=github.com\n"), &out)
+	if err == nil || !strings.Contains(err.Error(), "vauth login") {
+		t.Fatalf("got %v, want an invalid token error", err)
+	}
+}
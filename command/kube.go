@@ -0,0 +1,130 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// execCredential is the client.authentication.k8s.io/v1 ExecCredential
+// printed for the kubectl exec credential plugins.
+type execCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+type execCredentialStatus struct {
+	Token               string `json:"token"`
+	ExpirationTimestamp string `json:"expirationTimestamp,omitempty"`
+}
+
+// secretField returns the string value of field in the secret data, looking
+// also into the nested data of the KV v2 secrets.
+func secretField(secret *api.Secret, field string) (string, bool) {
+	if secret == nil || secret.Data == nil {
+		return "", false
+	}
+	if v, ok := secret.Data[field].(string); ok {
+		return v, true
+	}
+	if data, ok := secret.Data["data"].(map[string]interface{}); ok {
+		v, ok := data[field].(string)
+		return v, ok
+	}
+	return "", false
+}
+
+// newExecCredential returns the ExecCredential holding field of secret,
+// expiring with the secret lease.
+func newExecCredential(secret *api.Secret, field string, now time.Time) (*execCredential, error) {
+	token, ok := secretField(secret, field)
+	if !ok || token == "" {
+		return nil, fmt.Errorf("%s field not found", field)
+	}
+	cred := &execCredential{
+		APIVersion: "client.authentication.k8s.io/v1",
+		Kind:       "ExecCredential",
+		Status:     execCredentialStatus{Token: token},
+	}
+	if secret.LeaseDuration > 0 {
+		lease := time.Duration(secret.LeaseDuration) * time.Second
+		cred.Status.ExpirationTimestamp = now.Add(lease).UTC().Format(time.RFC3339)
+	}
+	return cred, nil
+}
+
+func init() {
+	rootCmd.AddCommand(kubeCredentialCmd)
+	kubeCredentialCmd.Flags().String("path", "", "Vault path of the cluster token (e.g. kubernetes/creds/app or secret/data/kube)")
+	kubeCredentialCmd.Flags().String("field", "token", "Field of the secret holding the token")
+	kubeCredentialCmd.Flags().String("profile", os.Getenv("VAUTH_PROFILE"), "Profile used to log in when the stored token is missing or expired")
+}
+
+var kubeCredentialCmd = &cobra.Command{
+	Use:   "kube-credential --path <vault path> [K=V...]",
+	Short: "Kubernetes client-go exec credential plugin",
+	Long: `This subcommand reads a Kubernetes token from Vault and prints it as an
+ExecCredential (client.authentication.k8s.io/v1) for the kubeconfig exec
+credential plugins. The expirationTimestamp is the one of the secret lease.
+
+When K=V data is given the path is written instead of read, as required by the
+Kubernetes secrets engine, e.g.
+
+    vauth kube-credential --path kubernetes/creds/app --field service_account_token \
+        kubernetes_namespace=default
+
+When the stored token is missing or expired, vauth logs in with --profile first.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := cmd.Flags().GetString("path")
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			return fmt.Errorf("No path provided")
+		}
+		field, err := cmd.Flags().GetString("field")
+		if err != nil {
+			return err
+		}
+		profileName, err := cmd.Flags().GetString("profile")
+		if err != nil {
+			return err
+		}
+		data, err := parseArgsData(os.Stdin, args)
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := profileClient(ctx, profileName)
+		if err != nil {
+			return err
+		}
+		var secret *api.Secret
+		if len(data) > 0 {
+			secret, err = client.Logical().Write(path, data)
+		} else {
+			secret, err = client.Logical().Read(path)
+		}
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			return fmt.Errorf("no secret found at %s", path)
+		}
+
+		cred, err := newExecCredential(secret, field, time.Now())
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(os.Stdout).Encode(cred)
+	},
+}
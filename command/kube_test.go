@@ -0,0 +1,69 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestNewExecCredential(t *testing.T) {
+	now := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+	credTests := []struct {
+		name       string
+		secret     *api.Secret
+		field      string
+		wantToken  string
+		wantExpiry string
+		wantErr    bool
+	}{
+		{
+			name: "kubernetes secrets engine",
+			secret: &api.Secret{
+				LeaseDuration: 600,
+				Data:          map[string]interface{}{"service_account_token": "eyJk8s"},
+			},
+			field:      "service_account_token",
+			wantToken:  "eyJk8s",
+			wantExpiry: "2019-05-01T10:10:00Z",
+		},
+		{
+			name: "kv v2",
+			secret: &api.Secret{
+				Data: map[string]interface{}{"data": map[string]interface{}{"token": "kvtoken"}},
+			},
+			field:     "token",
+			wantToken: "kvtoken",
+		},
+		{
+			name:    "missing field",
+			secret:  &api.Secret{Data: map[string]interface{}{"other": "x"}},
+			field:   "token",
+			wantErr: true,
+		},
+	}
+	for _, tt := range credTests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred, err := newExecCredential(tt.secret, tt.field, now)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.field) {
+					t.Fatalf("expected a missing field error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cred.APIVersion != "client.authentication.k8s.io/v1" || cred.Kind != "ExecCredential" {
+				t.Errorf("unexpected type %s %s", cred.APIVersion, cred.Kind)
+			}
+			if cred.Status.Token != tt.wantToken {
+				t.Errorf("got token %q, want %q", cred.Status.Token, tt.wantToken)
+			}
+			if cred.Status.ExpirationTimestamp != tt.wantExpiry {
+				t.Errorf("got expiration %q, want %q", cred.Status.ExpirationTimestamp, tt.wantExpiry)
+			}
+		})
+	}
+}
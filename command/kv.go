@@ -0,0 +1,338 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// renderPath renders the Vault path template with data.
+func renderPath(pathTemplate string, data interface{}) (string, error) {
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(pathTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid path template: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid path template: %s", err)
+	}
+	return buf.String(), nil
+}
+
+// kvMount returns the mount path, with trailing slash, and the version of
+// the KV engine of path, read from sys/internal/ui/mounts. Vault versions
+// without that endpoint only have KV v1.
+func kvMount(client *api.Client, path string) (string, int, error) {
+	r := client.NewRequest("GET", "/v1/sys/internal/ui/mounts/"+path)
+	resp, err := client.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		return "", 1, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("error detecting the KV version of %s: %s", path, err)
+	}
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", 0, fmt.Errorf("no mount found for %s", path)
+	}
+	mount, _ := secret.Data["path"].(string)
+	version := 1
+	if options, ok := secret.Data["options"].(map[string]interface{}); ok {
+		if v, ok := options["version"].(string); ok && v != "" {
+			if version, err = strconv.Atoi(v); err != nil {
+				return "", 0, fmt.Errorf("invalid KV version %q", v)
+			}
+		}
+	}
+	return mount, version, nil
+}
+
+// kvPath is a KV secret, or directory of secrets, resolved to the API paths
+// of the engine version of its mount.
+type kvPath struct {
+	version  int
+	data     string
+	metadata string
+}
+
+// resolveKVPath resolves the path of a KV secret as accepted by vault kv,
+// e.g. secret/app, to its API paths: secret/data/app and secret/metadata/app
+// for KV v2, secret/app for KV v1. The data/ API paths of KV v2 are accepted
+// too.
+func resolveKVPath(client *api.Client, path string) (kvPath, error) {
+	path = strings.Trim(path, "/")
+	mount, version, err := kvMount(client, path)
+	if err != nil {
+		return kvPath{}, err
+	}
+	if version != 2 {
+		return kvPath{version: version, data: path, metadata: path}, nil
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(path+"/", mount), "data/")
+	rel = strings.TrimSuffix(rel, "/")
+	return kvPath{
+		version:  version,
+		data:     strings.TrimSuffix(mount+"data/"+rel, "/"),
+		metadata: strings.TrimSuffix(mount+"metadata/"+rel, "/"),
+	}, nil
+}
+
+// kvRead reads the KV secret at path. The data of KV v2 secrets is left
+// wrapped in the data field.
+func kvRead(client *api.Client, path string) (*api.Secret, error) {
+	p, err := resolveKVPath(client, path)
+	if err != nil {
+		return nil, err
+	}
+	return client.Logical().Read(p.data)
+}
+
+// kvWrite writes data to the KV secret at path, wrapping it as required by
+// KV v2.
+func kvWrite(client *api.Client, path string, data map[string]interface{}) error {
+	p, err := resolveKVPath(client, path)
+	if err != nil {
+		return err
+	}
+	if p.version == 2 {
+		data = map[string]interface{}{"data": data}
+	}
+	_, err = client.Logical().Write(p.data, data)
+	return err
+}
+
+// kvDelete deletes the KV secret at path, only its latest version for KV v2
+// as vault kv delete does.
+func kvDelete(client *api.Client, path string) error {
+	p, err := resolveKVPath(client, path)
+	if err != nil {
+		return err
+	}
+	_, err = client.Logical().Delete(p.data)
+	return err
+}
+
+// kvList lists the KV secrets in the directory path.
+func kvList(client *api.Client, path string) (*api.Secret, error) {
+	p, err := resolveKVPath(client, path)
+	if err != nil {
+		return nil, err
+	}
+	return client.Logical().List(p.metadata)
+}
+
+// kvGetRequest is a read of a KV secret by the kv get sub-command.
+type kvGetRequest struct {
+	path    string
+	version int
+}
+
+// kvGet reads the secret at path detecting the KV version of its mount. The
+// data of the KV v2 secrets is returned unwrapped, with the version
+// metadata.
+func kvGet(client *api.Client, r kvGetRequest) (data, metadata map[string]interface{}, err error) {
+	p, err := resolveKVPath(client, r.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var params map[string][]string
+	if p.version == 2 {
+		if r.version > 0 {
+			params = map[string][]string{"version": {strconv.Itoa(r.version)}}
+		}
+	} else if r.version > 0 {
+		return nil, nil, fmt.Errorf("--version is supported only by KV v2 secrets")
+	}
+
+	secret, err := client.Logical().ReadWithData(p.data, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil, fmt.Errorf("no value found at %s", r.path)
+	}
+	if p.version != 2 {
+		return secret.Data, nil, nil
+	}
+	data, _ = secret.Data["data"].(map[string]interface{})
+	if data == nil {
+		return nil, nil, fmt.Errorf("no value found at %s", r.path)
+	}
+	metadata, _ = secret.Data["metadata"].(map[string]interface{})
+	return data, metadata, nil
+}
+
+// formatValue formats a secret value as the Vault CLI does: strings as they
+// are and the other values as JSON.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeKVTable writes the secret data, preceded by its metadata, as a
+// Key/Value table.
+func writeKVTable(out io.Writer, data, metadata map[string]interface{}) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 4, ' ', 0)
+	if metadata != nil {
+		fmt.Fprintln(tw, "====== Metadata ======")
+		fmt.Fprintln(tw, "Key\tValue")
+		fmt.Fprintln(tw, "---\t-----")
+		for _, k := range sortedKeys(metadata) {
+			fmt.Fprintf(tw, "%s\t%s\n", k, formatValue(metadata[k]))
+		}
+		fmt.Fprintln(tw, "\n====== Data ======")
+	}
+	fmt.Fprintln(tw, "Key\tValue")
+	fmt.Fprintln(tw, "---\t-----")
+	for _, k := range sortedKeys(data) {
+		fmt.Fprintf(tw, "%s\t%s\n", k, formatValue(data[k]))
+	}
+	return tw.Flush()
+}
+
+// envName turns a secret key into an environment variable name: upper case
+// with the characters other than letters, digits and underscore replaced by
+// underscores.
+func envName(key string) string {
+	name := []rune(strings.ToUpper(key))
+	for i, r := range name {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' {
+			name[i] = '_'
+		}
+	}
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+		return "_" + string(name)
+	}
+	return string(name)
+}
+
+// kvEnvVars returns the secret data as environment variables.
+func kvEnvVars(data map[string]interface{}) []envVar {
+	vars := make([]envVar, 0, len(data))
+	for _, k := range sortedKeys(data) {
+		vars = append(vars, envVar{name: envName(k), value: formatValue(data[k])})
+	}
+	return vars
+}
+
+// writeKVSecret writes the secret data as requested by the kv get flags.
+func writeKVSecret(out io.Writer, data, metadata map[string]interface{}, field, format string, toEnv bool) error {
+	switch {
+	case field != "":
+		v, ok := data[field]
+		if !ok {
+			return fmt.Errorf("%s field not found", field)
+		}
+		_, err := fmt.Fprintln(out, formatValue(v))
+		return err
+	case toEnv:
+		return writeEnv(out, "dotenv", kvEnvVars(data))
+	case format == "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{"data": data, "metadata": metadata})
+	case format == "table":
+		return writeKVTable(out, data, metadata)
+	}
+	return fmt.Errorf("%s format not supported", format)
+}
+
+func init() {
+	rootCmd.AddCommand(kvCmd)
+	kvCmd.AddCommand(kvGetCmd)
+	kvGetCmd.Flags().String("field", "", "Print only the value of the given field")
+	kvGetCmd.Flags().Int("version", 0, "Version of the KV v2 secret, the latest when zero")
+	kvGetCmd.Flags().String("format", "table", "Output format: table or json")
+	kvGetCmd.Flags().Bool("to-env", false, "Print the fields as KEY=value lines of a .env file")
+}
+
+var kvCmd = &cobra.Command{
+	Use:   "kv",
+	Short: "Interact with the Vault KV secrets engine",
+}
+
+var kvGetCmd = &cobra.Command{
+	Use:   "get [--field F] [--version N] [--format table|json] [--to-env] <path>",
+	Short: "Read a KV secret",
+	Long: `This subcommand reads the secret at path with the stored token. The KV version
+of the mount is detected, so KV v2 secrets are read with the same path of the
+vault kv get command, e.g.
+
+    $ vauth kv get secret/app
+    $ vauth kv get --field password secret/app
+    $ vauth kv get --to-env secret/app > .env
+
+With --to-env the keys are turned into upper case variable names and the values
+are quoted as in .env files.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		field, err := cmd.Flags().GetString("field")
+		if err != nil {
+			return err
+		}
+		version, err := cmd.Flags().GetInt("version")
+		if err != nil {
+			return err
+		}
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return err
+		}
+		toEnv, err := cmd.Flags().GetBool("to-env")
+		if err != nil {
+			return err
+		}
+		if format != "table" && format != "json" {
+			return fmt.Errorf("%s format not supported", format)
+		}
+		cmd.SilenceUsage = true
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := sessionClient(ctx)
+		if err != nil {
+			return err
+		}
+		data, metadata, err := kvGet(client, kvGetRequest{path: args[0], version: version})
+		if err != nil {
+			return err
+		}
+		return writeKVSecret(os.Stdout, data, metadata, field, format, toEnv)
+	},
+}
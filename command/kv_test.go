@@ -0,0 +1,98 @@
+package command
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestKVGet(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.EnableKV("secret", 2)
+	vault.EnableKV("kv", 1)
+	vault.SetSecret("secret", "app", map[string]interface{}{"password": "old"})
+	vault.SetSecret("secret", "app", map[string]interface{}{"password": "new", "db-user": "app"})
+	vault.SetSecret("kv", "app", map[string]interface{}{"password": "v1"})
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		r    kvGetRequest
+		want string
+	}{
+		{kvGetRequest{path: "secret/app"}, "new"},
+		{kvGetRequest{path: "/secret/data/app"}, "new"},
+		{kvGetRequest{path: "secret/app", version: 1}, "old"},
+		{kvGetRequest{path: "kv/app"}, "v1"},
+	}
+	for _, tt := range tests {
+		data, _, err := kvGet(client, tt.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := data["password"]; got != tt.want {
+			t.Fatalf("got %v, want %v", got, tt.want)
+		}
+	}
+
+	if _, _, err := kvGet(client, kvGetRequest{path: "kv/app", version: 1}); err == nil {
+		t.Fatal("expected an error with a version of a KV v1 secret")
+	}
+	if _, _, err := kvGet(client, kvGetRequest{path: "secret/missing"}); err == nil {
+		t.Fatal("expected an error with a missing secret")
+	}
+}
+
+func TestWriteKVSecret(t *testing.T) {
+	data := map[string]interface{}{
+		"db-user":  "app",
+		"password": `p"w`,
+		"ports":    []interface{}{80, 443},
+	}
+	tests := []struct {
+		field, format string
+		toEnv         bool
+		want          string
+	}{
+		{field: "password", format: "table", want: "p\"w\n"},
+		{field: "ports", format: "table", want: "[80,443]\n"},
+		{format: "table", toEnv: true, want: "DB_USER=\"app\"\nPASSWORD=\"p\\\"w\"\nPORTS=\"[80,443]\"\n"},
+		{format: "table", want: "Key         Value\n---         -----\ndb-user     app\npassword    p\"w\nports       [80,443]\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := writeKVSecret(&out, data, nil, tt.field, tt.format, tt.toEnv); err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Fatalf("got %q, want %q", got, tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeKVSecret(&out, data, nil, "missing", "table", false); err == nil {
+		t.Fatal("expected an error with a missing field")
+	}
+	if err := writeKVSecret(&out, data, nil, "", "json", false); err != nil || !strings.Contains(out.String(), `"db-user": "app"`) {
+		t.Fatalf("unexpected json output %q, %v", out.String(), err)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"password":   "PASSWORD",
+		"db-user":    "DB_USER",
+		"api.key":    "API_KEY",
+		"1st_secret": "_1ST_SECRET",
+	}
+	for key, want := range tests {
+		if got := envName(key); got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
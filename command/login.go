@@ -1,97 +1,39 @@
 package command
 
 import (
+	"context"
 	"fmt"
-	"github.com/hashicorp/vault/api"
-	credAws "github.com/hashicorp/vault/builtin/credential/aws"
-	credCert "github.com/hashicorp/vault/builtin/credential/cert"
-	credGitHub "github.com/hashicorp/vault/builtin/credential/github"
-	credLdap "github.com/hashicorp/vault/builtin/credential/ldap"
-	credOkta "github.com/hashicorp/vault/builtin/credential/okta"
-	credToken "github.com/hashicorp/vault/builtin/credential/token"
-	credUserpass "github.com/hashicorp/vault/builtin/credential/userpass"
 	vt "github.com/mauromedda/vauth/command/token"
+	"github.com/mauromedda/vauth/pkg/login"
 	"github.com/spf13/cobra"
 	"io"
 	"os"
 )
 
-// LoginHandler is the interface that any auth handlers must implement to enable
-// auth via the CLI.
-type LoginHandler interface {
-	Auth(*api.Client, map[string]string) (*api.Secret, error)
-	Help() string
-}
-
-// LoginHandlers is an k:v datatype with authentication method type and
-// the related vault Handler
-var LoginHandlers = map[string]LoginHandler{
-	"aws":    &credAws.CLIHandler{},
-	"cert":   &credCert.CLIHandler{},
-	"github": &credGitHub.CLIHandler{},
-	"ldap":   &credLdap.CLIHandler{},
-	"okta":   &credOkta.CLIHandler{},
-	"radius": &credUserpass.CLIHandler{
-		DefaultMount: "radius",
-	},
-	"token": &credToken.CLIHandler{},
-	"userpass": &credUserpass.CLIHandler{
-		DefaultMount: "userpass",
-	},
-}
+// Login function returns an error o print the token saved inside the ~/.vault-token file.
+// The login is aborted when ctx is done. The errors wrap the login.Error
+// returned by login.Authenticate, matched with errors.Is or errors.As.
+func Login(ctx context.Context, opts login.Options, out io.Writer) error {
+	// Store the token in the local client
+	tokenHelper := &vt.InternalTokenHelper{}
+	tokenHelper.PopulateTokenPath()
+	opts.TokenSink = tokenHelper
 
-// Login function returns an error o print the token saved inside the ~/.vault-token file
-func Login(client *api.Client, method string, loginConfig map[string]string, out io.Writer) error {
-	authConfig := map[string]string{}
-	clih, ok := LoginHandlers[method]
-	if !ok {
-		return fmt.Errorf("%s method not supported", method)
-	}
-	if method == "userpass" || method == "ldap" {
-		username, ok := loginConfig["username"]
-		if !ok {
-			username = UsernameFromEnv()
-			if username == "" {
-				return fmt.Errorf("'username' not supplied and neither 'LOGNAME' nor 'USER' env vars set")
-			}
-		}
-		password, ok := loginConfig["password"]
-		if !ok {
-			password = PasswordFromEnv()
-		}
-		authConfig = map[string]string{
-			"username": username,
-			"method":   method,
-		}
-		if password != "" {
-			authConfig["password"] = password
+	sec, err := login.Authenticate(ctx, opts)
+	if err != nil && sec == nil {
+		if help := login.Help(opts.Method); help != "" && ctx.Err() == nil {
+			return fmt.Errorf("%w\n%s", err, help)
 		}
-		for k, v := range loginConfig {
-			authConfig[k] = v
-		}
-	} else {
-		authConfig = loginConfig
-	}
-	sec, err := clih.Auth(client, authConfig)
-	if err != nil {
-		return fmt.Errorf("%s\n%s", err, clih.Help())
+		return err
 	}
 
-	tokenID, err := sec.TokenID()
+	tokenID, _ := sec.TokenID()
 	if err != nil {
-		return fmt.Errorf("No token available")
-	}
-
-	// Store the token in the local client
-	tokenHelper := vt.InternalTokenHelper{}
-	tokenHelper.PopulateTokenPath()
-
-	if err := tokenHelper.Store(tokenID); err != nil {
-		fmt.Fprintf(out, "Error storing token: %s", err)
+		// Reported on stderr with the error: out may be piped elsewhere
 		return fmt.Errorf(
-			"Authentication was successful, but the token was not persisted. The "+
+			"Authentication was successful, but the token was not persisted: %w. The "+
 				"resulting token is shown below for your records.\n"+
-				"TokenID: %s", tokenID)
+				"TokenID: %s", err, tokenID)
 	}
 	fmt.Fprintf(out, `Success! You are now authenticated. The token information displayed
 below is already stored in the token helper. You do NOT need to run
@@ -104,10 +46,99 @@ TokenID: %s
 
 func init() {
 	rootCmd.AddCommand(loginCmd)
-	loginCmd.Flags().StringP("method", "m", "token", "Authentication method for Vault")
-	loginCmd.Flags().StringP("path", "p", "", `Remote path in Vault where the auth method is enabled.
+	addLoginFlags(loginCmd)
+}
+
+// addLoginFlags adds to cmd the flags configuring a login, read back by
+// loginOptions.
+func addLoginFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("method", "m", "token", "Authentication method for Vault")
+	cmd.Flags().StringP("path", "p", "", `Remote path in Vault where the auth method is enabled.
 This defaults to the TYPE of method (e.g. userpass -> userpass/).`)
+	cmd.Flags().Int("retry", 0, "Number of retries of the requests failing with a transient error (5xx, 429, connection refused)")
+	cmd.Flags().Duration("retry-max-wait", login.DefaultRetryMaxWait, "Maximum backoff between two retries")
+	cmd.Flags().Bool("wait-for-unseal", false, "Wait until Vault is active and unsealed before authenticating")
+	cmd.Flags().String("aws-profile", "", "AWS profile of the shared configuration files used by the aws method")
+	cmd.Flags().String("aws-region", "", `Region of the STS endpoint signed by the aws method.
+This defaults to the global endpoint.`)
+	cmd.Flags().String("cert-name", "", "Certificate role of the cert method to authenticate against")
+	cmd.Flags().Bool("explain", false, "Print the credential source and the signed endpoint used by the login, without the secrets")
+}
 
+// methodFlags maps the flags specific to a method to the k=v parameter they
+// set, unless given as arg.
+var methodFlags = map[string]struct{ method, param string }{
+	"aws-profile": {"aws", "profile"},
+	"aws-region":  {"aws", "region"},
+	"cert-name":   {"cert", "name"},
+}
+
+// loginOptions returns the login options set by the flags added with
+// addLoginFlags and by the k=v args.
+func loginOptions(cmd *cobra.Command, args []string) (login.Options, error) {
+	var opts login.Options
+	method, err := cmd.Flags().GetString("method")
+	if err != nil {
+		return opts, err
+	}
+	authPath, err := cmd.Flags().GetString("path")
+	if err != nil {
+		return opts, err
+	}
+	retry, err := cmd.Flags().GetInt("retry")
+	if err != nil {
+		return opts, err
+	}
+	retryMaxWait, err := cmd.Flags().GetDuration("retry-max-wait")
+	if err != nil {
+		return opts, err
+	}
+	waitForUnseal, err := cmd.Flags().GetBool("wait-for-unseal")
+	if err != nil {
+		return opts, err
+	}
+	explain, err := cmd.Flags().GetBool("explain")
+	if err != nil {
+		return opts, err
+	}
+
+	// Pull the Hashicorp Vault fake stdin if needed
+	stdin := (io.Reader)(os.Stdin)
+	authConfig, err := parseArgsDataString(stdin, args)
+	if err != nil {
+		return opts, usageError(err)
+	}
+	for flag, p := range methodFlags {
+		value, err := cmd.Flags().GetString(flag)
+		if err != nil {
+			return opts, err
+		}
+		if value == "" {
+			continue
+		}
+		if method != p.method {
+			return opts, usageError(fmt.Errorf("--%s is only supported by the %s method", flag, p.method))
+		}
+		if _, ok := authConfig[p.param]; !ok {
+			authConfig[p.param] = value
+		}
+	}
+
+	opts = login.Options{
+		Method: method,
+		Mount:  authPath,
+		Params: authConfig,
+		Retry: login.RetryPolicy{
+			Max:     retry,
+			MaxWait: retryMaxWait,
+		},
+		WaitForUnseal: waitForUnseal,
+		Logger:        logger,
+	}
+	if explain {
+		opts.Explain = os.Stderr
+	}
+	return opts, nil
 }
 
 var loginCmd = &cobra.Command{
@@ -116,36 +147,27 @@ var loginCmd = &cobra.Command{
 	Long: `This subcommand authenticate the client to Vault using the provided method.
 The login sub-command and the related methods accept the same parameter of the mainstream Hashicorp Vault CLI.
 
-Valid methods are: aws, ldap, token, userpass, radius, github, okta and cert.
+Valid methods are: aws, ldap, token, userpass, radius, github, okta, cert, gha, gitlab, kubernetes, azure and gcp.
+
+When no method is provided inside GitHub Actions or GitLab CI, the gha or gitlab
+method is used to log in with the job ID token.
 `,
 	SilenceUsage: false,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if !cmd.Flags().Changed("method") {
-			return fmt.Errorf("No authentication method provided")
-		}
-		method, err := cmd.Flags().GetString("method")
+		opts, err := loginOptions(cmd, args)
 		if err != nil {
 			return err
 		}
-		authPath, err := cmd.Flags().GetString("path")
-		if err != nil {
-			authPath = EnsureTrailingSlash(method)
-		}
-
-		// Pull the Hashicorp Vault fake stdin if needed
-		stdin := (io.Reader)(os.Stdin)
-		stdout := os.Stdout
-		authConfig, err := parseArgsDataString(stdin, args[1:])
-		if authConfig["mount"] == "" && authPath != "" {
-			authConfig["mount"] = authPath
-		}
-		if err != nil {
-			return err
+		if !cmd.Flags().Changed("method") {
+			// Inside GitHub Actions and GitLab CI use the job ID token
+			if opts.Method = login.DetectMethod(); opts.Method == "" {
+				return usageError(fmt.Errorf("No authentication method provided"))
+			}
 		}
 
-		client, err := NewClient(nil)
-
-		if err := Login(client, method, authConfig, stdout); err != nil {
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		if err := Login(ctx, opts, os.Stdout); err != nil {
 			cmd.SilenceUsage = true
 			return err
 		}
@@ -3,10 +3,12 @@ package command
 import (
 	"bytes"
 	"context"
-	"fmt"
-	"github.com/hashicorp/vault/api"
 	vt "github.com/mauromedda/vauth/command/token"
-	testcontainers "github.com/testcontainers/testcontainers-go"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+	"github.com/mauromedda/vauth/pkg/login"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -18,58 +20,19 @@ func TestLogin(t *testing.T) {
 			t.Errorf("got %q want %q", got, want)
 		}
 	}
-	token := "s6gjRs4pYBO4pyDGyp73e8Zmt"
-	ctx := context.Background()
-	req := testcontainers.ContainerRequest{
-		Image:        "vault",
-		ExposedPorts: []string{"8200/tcp"},
-		Cmd:          "server -dev",
-		Env: map[string]string{
-			"VAULT_DEV_ROOT_TOKEN_ID":  token,
-			"VAULT_DEV_LISTEN_ADDRESS": "0.0.0.0:8200",
-		},
-	}
-	vaultC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-		ContainerRequest: req,
-		Started:          true,
-	})
-	if err != nil {
-		t.Error(err)
-	}
-	// At the end of the test remove the container
-	defer vaultC.Terminate(ctx)
-	// Retrieve the container IP
-	ip, err := vaultC.Host(ctx)
-	if err != nil {
-		t.Error(err)
-	}
-	// Retrieve the port mapped to port 8200
-	port, err := vaultC.MappedPort(ctx, "8200")
-	if err != nil {
-		t.Error(err)
-	}
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddUser("userpass", "test", "test", "default")
+	// Never touch the token of the user running the tests
+	_, restore := withHome(t)
+	defer restore()
 
-	address := fmt.Sprintf("http://%s:%s", ip, port.Port())
-	client, _ := NewClient(nil)
-	client.SetAddress(address)
-	client.SetToken(token)
-	authOpts := &api.EnableAuthOptions{
-		Type: "userpass",
-		Config: api.AuthConfigInput{
-			DefaultLeaseTTL: "600",
-			MaxLeaseTTL:     "800",
-		},
-	}
-	// Enable the userpass authentication
-	if err := client.Sys().EnableAuthWithOptions("userpass", authOpts); err != nil {
-		t.Fatal(err)
-	}
-	if _, err := client.Logical().Write("auth/userpass/users/test", map[string]interface{}{
-		"password": "test",
-		"policies": "default",
-	}); err != nil {
+	client, err := NewClient(nil)
+	if err != nil {
 		t.Fatal(err)
 	}
+	client.SetAddress(vault.URL)
+	token := vault.RootToken
 
 	loginTest := []struct {
 		name   string
@@ -81,14 +44,16 @@ func TestLogin(t *testing.T) {
 		{name: "userpass wrong login", method: "userpass", params: map[string]string{"username": "testWrong", "password": "test"}, want: "invalid username or password"},
 		{name: "token successful login", method: "token", params: map[string]string{"token": token}, want: "Success! You are now authenticated."},
 		{name: "token wrong login", method: "token", params: map[string]string{"token": "tokenWrong"}, want: "permission denied"},
+		{name: "unsupported method", method: "foo", params: map[string]string{}, want: "foo method not supported"},
 	}
 	tokenHelper := vt.InternalTokenHelper{}
+	ctx := context.Background()
 	for _, tt := range loginTest {
 		t.Run(tt.name, func(t *testing.T) {
 			// Erase the token in the local client
 			defer tokenHelper.Erase()
 			got := &bytes.Buffer{}
-			if err := Login(client, tt.method, tt.params, got); err != nil {
+			if err := Login(ctx, login.Options{Method: tt.method, Params: tt.params, Client: client}, got); err != nil {
 				checkLogins(t, err.Error(), tt.want)
 			} else {
 				checkLogins(t, got.String(), tt.want)
@@ -97,3 +62,114 @@ func TestLogin(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginOptionsMethodFlags(t *testing.T) {
+	methodFlagTests := []struct {
+		name    string
+		flags   map[string]string
+		args    []string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:  "profile and region",
+			flags: map[string]string{"method": "aws", "aws-profile": "dev", "aws-region": "eu-west-1"},
+			want:  map[string]string{"profile": "dev", "region": "eu-west-1"},
+		},
+		{
+			name:  "args take precedence",
+			flags: map[string]string{"method": "aws", "aws-region": "eu-west-1"},
+			args:  []string{"region=us-west-2"},
+			want:  map[string]string{"region": "us-west-2"},
+		},
+		{
+			// The first argument used to be dropped
+			name:  "every argument",
+			flags: map[string]string{"method": "userpass"},
+			args:  []string{"username=alice", "password=secret"},
+			want:  map[string]string{"username": "alice", "password": "secret"},
+		},
+		{
+			name:  "cert name",
+			flags: map[string]string{"method": "cert", "cert-name": "web"},
+			want:  map[string]string{"name": "web"},
+		},
+		{
+			name:    "other method",
+			flags:   map[string]string{"method": "userpass", "aws-profile": "dev"},
+			wantErr: "--aws-profile is only supported by the aws method",
+		},
+	}
+	for _, tt := range methodFlagTests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			addLoginFlags(cmd)
+			for k, v := range tt.flags {
+				cmd.Flags().Set(k, v)
+			}
+			opts, err := loginOptions(cmd, tt.args)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range tt.want {
+				if got := opts.Params[k]; got != want {
+					t.Fatalf("%s: got %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginExitCodes(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddUser("userpass", "test", "test", "default")
+	client, err := vault.Client("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, restore := withHome(t)
+	defer restore()
+
+	exitTests := []struct {
+		name   string
+		method string
+		params map[string]string
+		setup  func() error
+		want   int
+	}{
+		{name: "denied", method: "userpass", params: map[string]string{"username": "test", "password": "wrong"}, want: ExitCodeAuthDenied},
+		{name: "unsupported method", method: "foo", want: ExitCodeUnsupportedMethod},
+		{
+			name:   "persist failure",
+			method: "userpass",
+			params: map[string]string{"username": "test", "password": "test"},
+			// A directory in place of the token file
+			setup: func() error { return os.Mkdir(filepath.Join(home, ".vault-token"), 0700) },
+			want:  ExitCodePersist,
+		},
+	}
+	for _, tt := range exitTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				if err := tt.setup(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out := &bytes.Buffer{}
+			err := Login(context.Background(), login.Options{Method: tt.method, Params: tt.params, Client: client}, out)
+			if got := exitCode(err); got != tt.want {
+				t.Fatalf("got exit code %d (%v), want %d", got, err, tt.want)
+			}
+			if out.Len() > 0 {
+				t.Fatalf("got output %q, want none", out.String())
+			}
+		})
+	}
+}
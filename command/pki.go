@@ -0,0 +1,417 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// The files written by pki issue in the --out-dir, with their permissions.
+const (
+	pkiCertFile   = "cert.pem"
+	pkiKeyFile    = "key.pem"
+	pkiCAFile     = "ca.pem"
+	pkiBundleFile = "bundle.pem"
+	// pkiRequestFile records the request of the certificate, to issue it
+	// again when the request changes.
+	pkiRequestFile = "request.json"
+)
+
+// pkiIssueRequest is a certificate issuance of the pki issue sub-command.
+type pkiIssueRequest struct {
+	mount    string
+	role     string
+	cn       string
+	altNames []string
+	ipSANs   []string
+	ttl      string
+}
+
+// encode returns the JSON recorded in the pkiRequestFile, the same for the
+// requests of the same certificate.
+func (r pkiIssueRequest) encode() ([]byte, error) {
+	altNames := append([]string(nil), r.altNames...)
+	sort.Strings(altNames)
+	ipSANs := append([]string(nil), r.ipSANs...)
+	sort.Strings(ipSANs)
+	return json.Marshal(map[string]interface{}{
+		"mount":       strings.Trim(r.mount, "/"),
+		"role":        r.role,
+		"common_name": r.cn,
+		"alt_names":   altNames,
+		"ip_sans":     ipSANs,
+		"ttl":         r.ttl,
+	})
+}
+
+// pkiCertificate is a certificate issued by the PKI secrets engine.
+type pkiCertificate struct {
+	certificate string
+	privateKey  string
+	caChain     []string
+}
+
+// issueCertificate issues a certificate through <mount>/issue/<role>.
+func issueCertificate(client *api.Client, r pkiIssueRequest) (*pkiCertificate, error) {
+	data := map[string]interface{}{
+		"common_name": r.cn,
+	}
+	if len(r.altNames) > 0 {
+		data["alt_names"] = strings.Join(r.altNames, ",")
+	}
+	if len(r.ipSANs) > 0 {
+		data["ip_sans"] = strings.Join(r.ipSANs, ",")
+	}
+	if r.ttl != "" {
+		data["ttl"] = r.ttl
+	}
+	path := fmt.Sprintf("%s/issue/%s", strings.Trim(r.mount, "/"), r.role)
+	secret, err := client.Logical().Write(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("empty response from %s", path)
+	}
+
+	cert := &pkiCertificate{}
+	cert.certificate, _ = secret.Data["certificate"].(string)
+	cert.privateKey, _ = secret.Data["private_key"].(string)
+	if cert.certificate == "" || cert.privateKey == "" {
+		return nil, fmt.Errorf("no certificate or private key in the response of %s", path)
+	}
+	if chain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, c := range chain {
+			if s, ok := c.(string); ok && s != "" {
+				cert.caChain = append(cert.caChain, s)
+			}
+		}
+	}
+	if len(cert.caChain) == 0 {
+		if ca, ok := secret.Data["issuing_ca"].(string); ok && ca != "" {
+			cert.caChain = []string{ca}
+		}
+	}
+	return cert, nil
+}
+
+// pemJoin joins PEM blocks, one per line.
+func pemJoin(blocks ...string) []byte {
+	var b strings.Builder
+	for _, block := range blocks {
+		b.WriteString(strings.TrimSpace(block))
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// writeCertificate writes to dir the certificate, the private key readable
+// only by the user, the CA chain, the bundle of certificate, CA chain and
+// private key, also readable only by the user, and the request r. All the
+// files are written to temporary files first and then renamed, so that a
+// failure never leaves a new key next to the old certificate.
+func writeCertificate(dir string, r pkiIssueRequest, cert *pkiCertificate) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	request, err := r.encode()
+	if err != nil {
+		return err
+	}
+	chain := append([]string{cert.certificate}, cert.caChain...)
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		// The key first, the certificate is what readers check to detect an
+		// issuance
+		{pkiKeyFile, pemJoin(cert.privateKey), 0600},
+		{pkiCAFile, pemJoin(cert.caChain...), 0644},
+		{pkiBundleFile, pemJoin(append(chain, cert.privateKey)...), 0600},
+		{pkiRequestFile, append(request, '\n'), 0644},
+		{pkiCertFile, pemJoin(cert.certificate), 0644},
+	}
+	var temps []string
+	defer func() {
+		for _, t := range temps {
+			os.Remove(t)
+		}
+	}()
+	for _, f := range files {
+		t, err := writeTempFile(dir, f.name, f.data, f.mode)
+		if err != nil {
+			return err
+		}
+		temps = append(temps, t)
+	}
+	for i, f := range files {
+		if err := os.Rename(temps[i], filepath.Join(dir, f.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// issuedFor reports whether the certificate in dir was issued for r.
+func issuedFor(dir string, r pkiIssueRequest) bool {
+	recorded, err := ioutil.ReadFile(filepath.Join(dir, pkiRequestFile))
+	if err != nil {
+		return false
+	}
+	request, err := r.encode()
+	return err == nil && bytes.Equal(bytes.TrimSpace(recorded), request)
+}
+
+// readCertificate parses the certificate written in dir.
+func readCertificate(dir string) (*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, pkiCertFile))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate in %s", filepath.Join(dir, pkiCertFile))
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// renewalTime returns when cert needs to be issued again: renewBefore its
+// expiration or, when zero, at two thirds of its lifetime.
+func renewalTime(cert *x509.Certificate, renewBefore time.Duration) time.Time {
+	if renewBefore > 0 {
+		return cert.NotAfter.Add(-renewBefore)
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(lifetime * 2 / 3)
+}
+
+// pkiJob issues a certificate into a directory.
+type pkiJob struct {
+	client      *api.Client
+	request     pkiIssueRequest
+	outDir      string
+	renewBefore time.Duration
+	reload      string
+	stdout      io.Writer
+	stderr      io.Writer
+	now         func() time.Time
+}
+
+// run issues the certificate unless the one in the out dir is still fresh,
+// i.e. expiring in more than renewBefore, and was issued for the same
+// request. It returns the certificate in the out dir and whether it was
+// issued.
+func (j *pkiJob) run() (*x509.Certificate, bool, error) {
+	if j.renewBefore > 0 {
+		existing, err := readCertificate(j.outDir)
+		if err == nil && j.now().Before(renewalTime(existing, j.renewBefore)) && issuedFor(j.outDir, j.request) {
+			return existing, false, nil
+		}
+	}
+	cert, err := j.issue()
+	if err != nil {
+		return nil, false, err
+	}
+	return cert, true, nil
+}
+
+// issue issues the certificate and writes it to the out dir.
+func (j *pkiJob) issue() (*x509.Certificate, error) {
+	issued, err := issueCertificate(j.client, j.request)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCertificate(j.outDir, j.request, issued); err != nil {
+		return nil, err
+	}
+	return readCertificate(j.outDir)
+}
+
+// watch issues the certificate again at its renewal time, running the reload
+// command after each issuance, until ctx is done. Failed issuances are
+// retried with an exponential backoff and with the client returned by
+// newClient, e.g. when the token expired.
+func (j *pkiJob) watch(ctx context.Context, cert *x509.Certificate, newClient func() (*api.Client, error)) error {
+	backoff := agentMinBackoff
+	next := renewalTime(cert, j.renewBefore)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(next.Sub(j.now())):
+		}
+
+		issued, err := j.issue()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warn("certificate issuance failed, retrying", "backoff", backoff, "error", err)
+			if client, err := newClient(); err == nil {
+				j.client = client
+			}
+			next = j.now().Add(backoff)
+			if backoff *= 2; backoff > agentMaxBackoff {
+				backoff = agentMaxBackoff
+			}
+			continue
+		}
+		backoff = agentMinBackoff
+		next = renewalTime(issued, j.renewBefore)
+		if !next.After(j.now()) {
+			// The role max TTL is shorter than renewBefore
+			next = renewalTime(issued, 0)
+		}
+		if err := runReload(ctx, j.reload, j.stdout, j.stderr); err != nil {
+			logger.Error("reload command failed", "error", err)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(pkiCmd)
+	pkiCmd.AddCommand(pkiIssueCmd)
+	pkiIssueCmd.Flags().String("mount", "pki", "Path where the PKI secrets engine is mounted")
+	pkiIssueCmd.Flags().String("role", "", "Role of the PKI secrets engine")
+	pkiIssueCmd.Flags().String("cn", "", "Common name of the certificate")
+	pkiIssueCmd.Flags().StringSlice("alt-names", nil, "Subject alternative names, DNS names or emails")
+	pkiIssueCmd.Flags().StringSlice("ip-sans", nil, "IP subject alternative names")
+	pkiIssueCmd.Flags().String("ttl", "", "TTL of the certificate (e.g. 24h), the role default if empty")
+	pkiIssueCmd.Flags().String("out-dir", ".", "Directory of the cert.pem, key.pem, ca.pem and bundle.pem files")
+	pkiIssueCmd.Flags().Duration("renew-before", 0, `Skip the issuance while the existing certificate expires in more than this.
+In watch mode the certificate is issued again this long before it expires,
+at two thirds of its lifetime if zero`)
+	pkiIssueCmd.Flags().Bool("watch", false, "Issue the certificate again before it expires")
+	pkiIssueCmd.Flags().String("reload", "", "Shell command run after each issuance in watch mode")
+	pkiIssueCmd.Flags().String("profile", os.Getenv("VAUTH_PROFILE"), "Login profile used when the stored token is missing or expired")
+}
+
+var pkiCmd = &cobra.Command{
+	Use:   "pki",
+	Short: "Interact with the Vault PKI secrets engine",
+}
+
+var pkiIssueCmd = &cobra.Command{
+	Use:   "issue --role R --cn CN [--ttl 24h] [--out-dir DIR] [--watch [--reload CMD]]",
+	Short: "Issue a certificate and write it to files",
+	Long: `This subcommand issues a certificate through <mount>/issue/<role> and writes to
+the --out-dir:
+
+    cert.pem      the certificate (0644)
+    key.pem       the private key (0600)
+    ca.pem        the CA chain (0644)
+    bundle.pem    the certificate, the CA chain and the private key (0600)
+    request.json  the request of the certificate (0644)
+
+The files are written to temporary files first and then renamed together.
+
+With --renew-before the issuance is skipped while the certificate in the
+--out-dir expires in more than the given duration and was issued for the same
+--mount, --role, --cn, --alt-names, --ip-sans and --ttl, e.g.
+
+    $ vauth pki issue --role svc --cn svc.internal --ttl 24h --out-dir ./tls --renew-before 8h
+
+With --watch the certificate is issued again before it expires and the
+--reload command run after each issuance.
+
+The stored token is used; when it is missing or expired vauth logs in first
+with the --profile (or $VAUTH_PROFILE) defined in ~/.vauth/profiles.json.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var r pkiIssueRequest
+		var err error
+		if r.mount, err = cmd.Flags().GetString("mount"); err != nil {
+			return err
+		}
+		if r.role, err = cmd.Flags().GetString("role"); err != nil {
+			return err
+		}
+		if r.cn, err = cmd.Flags().GetString("cn"); err != nil {
+			return err
+		}
+		if r.altNames, err = cmd.Flags().GetStringSlice("alt-names"); err != nil {
+			return err
+		}
+		if r.ipSANs, err = cmd.Flags().GetStringSlice("ip-sans"); err != nil {
+			return err
+		}
+		if r.ttl, err = cmd.Flags().GetString("ttl"); err != nil {
+			return err
+		}
+		outDir, err := cmd.Flags().GetString("out-dir")
+		if err != nil {
+			return err
+		}
+		renewBefore, err := cmd.Flags().GetDuration("renew-before")
+		if err != nil {
+			return err
+		}
+		watch, err := cmd.Flags().GetBool("watch")
+		if err != nil {
+			return err
+		}
+		reload, err := cmd.Flags().GetString("reload")
+		if err != nil {
+			return err
+		}
+		profileName, err := cmd.Flags().GetString("profile")
+		if err != nil {
+			return err
+		}
+		if r.role == "" || r.cn == "" {
+			return fmt.Errorf("--role and --cn are required")
+		}
+		cmd.SilenceUsage = true
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := profileClient(ctx, profileName)
+		if err != nil {
+			return err
+		}
+		j := &pkiJob{
+			client:      client,
+			request:     r,
+			outDir:      outDir,
+			renewBefore: renewBefore,
+			reload:      reload,
+			stdout:      os.Stdout,
+			stderr:      os.Stderr,
+			now:         time.Now,
+		}
+		cert, issued, err := j.run()
+		if err != nil {
+			return err
+		}
+		if issued {
+			logger.Info("certificate issued", "serial", fmt.Sprintf("%x", cert.SerialNumber), "expiration", cert.NotAfter.Format(time.RFC3339))
+		} else {
+			logger.Info("certificate still valid, issuance skipped", "expiration", cert.NotAfter.Format(time.RFC3339))
+		}
+		if !watch {
+			return nil
+		}
+		if issued {
+			if err := runReload(ctx, reload, os.Stdout, os.Stderr); err != nil {
+				logger.Error("reload command failed", "error", err)
+			}
+		}
+		return j.watch(ctx, cert, func() (*api.Client, error) {
+			return profileClient(ctx, profileName)
+		})
+	},
+}
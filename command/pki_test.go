@@ -0,0 +1,259 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+// handlePKIIssue serves <mount>/issue/<role> issuing certificates valid for
+// lifetime, signed by a self-signed CA. It counts the issuances in issued.
+func handlePKIIssue(t *testing.T, vault *vaulttest.Server, lifetime time.Duration, issued *int32) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))
+
+	vault.HandleFunc("/v1/pki/issue/svc", func(w http.ResponseWriter, r *http.Request) {
+		if vault.Authorized(w, r) == nil {
+			return
+		}
+		n := atomic.AddInt32(issued, 1)
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		now := time.Now()
+		leaf := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(n) + 1),
+			Subject:      pkix.Name{CommonName: "svc.internal"},
+			NotBefore:    now,
+			NotAfter:     now.Add(lifetime),
+		}
+		der, _ := x509.CreateCertificate(rand.Reader, leaf, caTemplate, &key.PublicKey, caKey)
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		vaulttest.RespondData(w, map[string]interface{}{
+			"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+			"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+			"issuing_ca":  caPEM,
+			"ca_chain":    []string{caPEM},
+		})
+	})
+}
+
+// newPKIJob returns a job issuing into a temporary directory.
+func newPKIJob(t *testing.T, client *api.Client) *pkiJob {
+	dir, err := ioutil.TempDir("", "vauth-pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pkiJob{
+		client:  client,
+		request: pkiIssueRequest{mount: "pki", role: "svc", cn: "svc.internal", ttl: "24h"},
+		outDir:  filepath.Join(dir, "tls"),
+		stdout:  ioutil.Discard,
+		stderr:  ioutil.Discard,
+		now:     time.Now,
+	}
+}
+
+func TestPKIIssue(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	var issued int32
+	handlePKIIssue(t, vault, 24*time.Hour, &issued)
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := newPKIJob(t, client)
+	defer os.RemoveAll(filepath.Dir(j.outDir))
+
+	cert, ok, err := j.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || cert.Subject.CommonName != "svc.internal" {
+		t.Fatalf("unexpected certificate %v, issued %v", cert.Subject, ok)
+	}
+	modes := map[string]os.FileMode{
+		pkiCertFile:   0644,
+		pkiKeyFile:    0600,
+		pkiCAFile:     0644,
+		pkiBundleFile: 0600,
+	}
+	for name, want := range modes {
+		fi, err := os.Stat(filepath.Join(j.outDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode().Perm(); got != want {
+			t.Fatalf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	bundle, err := ioutil.ReadFile(filepath.Join(j.outDir, pkiBundleFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Count(string(bundle), "-----BEGIN"), 3; got != want {
+		t.Fatalf("got %v PEM blocks in the bundle, want %v", got, want)
+	}
+
+	// Fresh certificate: the issuance is skipped
+	j.renewBefore = time.Hour
+	if _, ok, err := j.run(); err != nil || ok {
+		t.Fatalf("expected the issuance to be skipped, got %v, %v", ok, err)
+	}
+	// Certificate expiring within renewBefore: issued again
+	j.renewBefore = 48 * time.Hour
+	if _, ok, err := j.run(); err != nil || !ok {
+		t.Fatalf("expected a new issuance, got %v, %v", ok, err)
+	}
+	if got, want := atomic.LoadInt32(&issued), int32(2); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// Fresh certificate of another request: issued again
+	j.renewBefore = time.Hour
+	j.request.altNames = []string{"svc.example.com"}
+	if _, ok, err := j.run(); err != nil || !ok {
+		t.Fatalf("expected a new issuance, got %v, %v", ok, err)
+	}
+	if got, want := atomic.LoadInt32(&issued), int32(3); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Only the svc role is served: the web role issuance fails
+	j.request.role = "web"
+	if _, ok, err := j.run(); err == nil {
+		t.Fatalf("expected the issuance for the web role, got %v", ok)
+	}
+	files, err := ioutil.ReadDir(j.outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Fatalf("got %d files, want the 5 of the certificate and no temporary file", len(files))
+	}
+}
+
+func TestRenewalTime(t *testing.T) {
+	notBefore := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(30 * time.Hour)}
+	tests := []struct {
+		renewBefore time.Duration
+		want        time.Time
+	}{
+		{0, notBefore.Add(20 * time.Hour)},
+		{6 * time.Hour, notBefore.Add(24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		if got := renewalTime(cert, tt.renewBefore); !got.Equal(tt.want) {
+			t.Fatalf("got %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func TestPKIWatch(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	var issued int32
+	handlePKIIssue(t, vault, 3*time.Second, &issued)
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := newPKIJob(t, client)
+	defer os.RemoveAll(filepath.Dir(j.outDir))
+	j.renewBefore = 2 * time.Second
+	var stdout bytes.Buffer
+	j.stdout = &stdout
+	j.reload = "echo reloaded"
+
+	cert, _, err := j.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+	newClient := func() (*api.Client, error) { return client, nil }
+	if err := j.watch(ctx, cert, newClient); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := atomic.LoadInt32(&issued); n < 2 || n > 5 {
+		t.Fatalf("expected the certificate to be issued again before it expires, got %d issuances", n)
+	}
+	if !strings.Contains(stdout.String(), "reloaded") {
+		t.Fatalf("expected the reload command to run, got %q", stdout.String())
+	}
+}
+
+func TestPKIWatchNewClient(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	var issued int32
+	handlePKIIssue(t, vault, 5*time.Second, &issued)
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := newPKIJob(t, client)
+	defer os.RemoveAll(filepath.Dir(j.outDir))
+	// NotAfter is truncated to the second: the renewal fails 2-3s after the
+	// first issuance, succeeds 1s later and is due again 2-3s after that
+	j.renewBefore = 2 * time.Second
+	cert, _, err := j.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token expired: the renewal fails until the client is replaced
+	expired, err := vault.Client("expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.client = expired
+	var newClients int32
+	newClient := func() (*api.Client, error) {
+		atomic.AddInt32(&newClients, 1)
+		return client, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 4500*time.Millisecond)
+	defer cancel()
+	if err := j.watch(ctx, cert, newClient); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := atomic.LoadInt32(&newClients); n != 1 {
+		t.Fatalf("got %d new clients, want 1", n)
+	}
+	if n := atomic.LoadInt32(&issued); n != 2 {
+		t.Fatalf("got %d issuances, want 2", n)
+	}
+}
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mauromedda/vauth/pkg/login"
+	"github.com/mitchellh/go-homedir"
+)
+
+// profile is a named login configuration stored in ~/.vauth/profiles.json,
+// used by the commands that log in unattended, e.g.
+//
+//	{
+//	  "profiles": {
+//	    "ci": {
+//	      "method": "userpass",
+//	      "args": ["username=ci", "password=@/run/secrets/vault-password"]
+//	    }
+//	  }
+//	}
+//
+// The args accept the same k=v syntax of the login sub-command.
+type profile struct {
+	Method string   `json:"method"`
+	Path   string   `json:"path"`
+	Args   []string `json:"args"`
+}
+
+// profilesPath returns the path of the profiles file: $VAUTH_PROFILES or
+// ~/.vauth/profiles.json.
+func profilesPath() (string, error) {
+	if path := os.Getenv("VAUTH_PROFILES"); path != "" {
+		return path, nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".vauth", "profiles.json"), nil
+}
+
+// loadProfile returns the profile name from the profiles file.
+func loadProfile(name string) (*profile, error) {
+	path, err := profilesPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the profiles: %s", err)
+	}
+	var config struct {
+		Profiles map[string]*profile `json:"profiles"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", path, err)
+	}
+	p, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%s profile not found in %s", name, path)
+	}
+	return p, nil
+}
+
+// loginOptions returns the options of a login using the profile.
+func (p *profile) loginOptions() (login.Options, error) {
+	params, err := parseArgsDataString(os.Stdin, p.Args)
+	if err != nil {
+		return login.Options{}, err
+	}
+	return login.Options{
+		Method: p.Method,
+		Mount:  p.Path,
+		Params: params,
+	}, nil
+}
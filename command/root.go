@@ -1,21 +1,192 @@
 package command
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/mauromedda/vauth/pkg/login"
 	"github.com/spf13/cobra"
 )
 
+const (
+	// ExitCodeError is the exit code used for the failures of no other kind.
+	ExitCodeError = 1
+	// ExitCodeUsage is the exit code used for invalid flags or arguments.
+	ExitCodeUsage = 2
+	// ExitCodeAuthDenied is the exit code used when Vault rejects the
+	// credentials.
+	ExitCodeAuthDenied = 3
+	// ExitCodeUnsupportedMethod is the exit code used for an unknown auth
+	// method.
+	ExitCodeUnsupportedMethod = 4
+	// ExitCodeUnavailable is the exit code used when Vault cannot be
+	// reached or answers with a server error.
+	ExitCodeUnavailable = 5
+	// ExitCodeSealed is the exit code used when Vault is sealed.
+	ExitCodeSealed = 6
+	// ExitCodePersist is the exit code used when the login succeeds but the
+	// token cannot be stored.
+	ExitCodePersist = 7
+	// ExitCodeTimeout is the exit code used when the operation does not
+	// complete within the --timeout.
+	ExitCodeTimeout = 124
+	// ExitCodeCanceled is the exit code used when the operation is
+	// cancelled by SIGINT or SIGTERM.
+	ExitCodeCanceled = 130
+)
+
+// exitCodes maps the kinds of errors to their exit code.
+var exitCodes = []struct {
+	kind error
+	code int
+}{
+	{login.ErrUsage, ExitCodeUsage},
+	{login.ErrAuthDenied, ExitCodeAuthDenied},
+	{login.ErrUnsupportedMethod, ExitCodeUnsupportedMethod},
+	{login.ErrUnavailable, ExitCodeUnavailable},
+	{login.ErrSealed, ExitCodeSealed},
+	{login.ErrPersist, ExitCodePersist},
+	{login.ErrTimeout, ExitCodeTimeout},
+	{context.DeadlineExceeded, ExitCodeTimeout},
+	{context.Canceled, ExitCodeCanceled},
+}
+
+// rootCtx is cancelled when vauth receives SIGINT or SIGTERM. The commands
+// derive their context from it through commandContext.
+var rootCtx = context.Background()
+
+// logger receives the logs of the commands, configured by the --log-level
+// and --log-format flags before every run.
+var logger = hclog.NewNullLogger()
+
 var rootCmd = &cobra.Command{
 	Use:   "vauth",
 	Short: "vauth Hashicorp Vault login tool",
 	Long:  `A simplified and lightweight CLI tool to manage Hashicorp Vault authentication methods.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return configureLogger(cmd, os.Stderr)
+	},
+}
+
+// helperCommands maps the names vauth can be symlinked as to the command run
+// in that case, e.g. docker-credential-vauth to docker-credential.
+var helperCommands = map[string]*cobra.Command{}
+
+func init() {
+	rootCmd.PersistentFlags().Duration("timeout", 0, `Maximum duration of the whole operation (e.g. 30s, 2m), including
+the whole run of agent and of the --watch modes. Zero means no timeout.`)
+	addLogFlags(rootCmd)
+}
+
+// addLogFlags adds to cmd, and its sub-commands, the flags configuring the
+// logs, read back by configureLogger.
+func addLogFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("log-level", "warn", "Level of the logs written to stderr: trace, debug, info, warn or error")
+	cmd.PersistentFlags().String("log-format", "standard", "Format of the logs: standard or json")
+}
+
+// configureLogger sets logger as requested by the --log-level and
+// --log-format flags of cmd, writing to out.
+func configureLogger(cmd *cobra.Command, out io.Writer) error {
+	level, err := cmd.Flags().GetString("log-level")
+	if err != nil {
+		return err
+	}
+	format, err := cmd.Flags().GetString("log-format")
+	if err != nil {
+		return err
+	}
+	if format != "standard" && format != "json" {
+		return fmt.Errorf("%s log format not supported", format)
+	}
+	l, err := login.NewLogger(out, level, format == "json")
+	if err != nil {
+		return err
+	}
+	logger = l
+	return nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+			// Restore the default handling: a second signal kills vauth
+			// if the shutdown hangs
+			signal.Stop(sigCh)
+		case <-ctx.Done():
+		}
+	}()
+	rootCtx = ctx
+
+	// Run as credential helper when invoked through a symlink
+	if cmd, ok := helperCommands[filepath.Base(os.Args[0])]; ok {
+		rootCmd.SetArgs(append([]string{cmd.Name()}, os.Args[1:]...))
+	}
+	markUsageErrors(rootCmd)
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// commandContext returns the context of a command run, bound to the
+// --timeout flag and to the signals handled by Execute. The timeout bounds
+// the long-running commands too, e.g. agent, ending them with exit code
+// ExitCodeTimeout.
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err == nil && timeout > 0 {
+		return context.WithTimeout(rootCtx, timeout)
+	}
+	return context.WithCancel(rootCtx)
+}
+
+// exitCode maps the error returned by a command to the process exit code.
+func exitCode(err error) int {
+	for _, c := range exitCodes {
+		if errors.Is(err, c.kind) {
+			return c.code
+		}
+	}
+	return ExitCodeError
+}
+
+// usageError marks err as an invalid usage of the command.
+func usageError(err error) error {
+	return &login.Error{Kind: login.ErrUsage, Err: err}
+}
+
+// markUsageErrors makes the flag and argument errors of cmd and its
+// sub-commands usage errors.
+func markUsageErrors(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(c *cobra.Command, err error) error {
+		return usageError(err)
+	})
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(c *cobra.Command, a []string) error {
+			if err := args(c, a); err != nil {
+				return usageError(err)
+			}
+			return nil
+		}
+	}
+	for _, c := range cmd.Commands() {
+		markUsageErrors(c)
 	}
 }
@@ -0,0 +1,99 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/pkg/login"
+	"github.com/spf13/cobra"
+)
+
+func TestConfigureLogger(t *testing.T) {
+	defaultLogger := logger
+	defer func() { logger = defaultLogger }()
+	const token = "s.0123456789abcdef01234567"
+
+	loggerTests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "default", want: ""},
+		{name: "debug", args: []string{"--log-level", "debug"}, want: "vauth: vault request: token=[REDACTED]"},
+		{name: "json", args: []string{"--log-level", "DEBUG", "--log-format", "json"}, want: `"token":"[REDACTED]"`},
+		{name: "invalid level", args: []string{"--log-level", "verbose"}, wantErr: "invalid log level"},
+		{name: "invalid format", args: []string{"--log-format", "xml"}, wantErr: "xml log format not supported"},
+	}
+	for _, tt := range loggerTests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			addLogFlags(cmd)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			var logs bytes.Buffer
+			err := configureLogger(cmd, &logs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger.Debug("vault request", "token", token)
+			if strings.Contains(logs.String(), token) {
+				t.Fatalf("token logged: %s", logs.String())
+			}
+			if !strings.Contains(logs.String(), tt.want) || (tt.want == "" && logs.Len() > 0) {
+				t.Fatalf("got %q, want %q", logs.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	exitTests := []struct {
+		err  error
+		want int
+	}{
+		{err: errors.New("boom"), want: ExitCodeError},
+		{err: usageError(errors.New("bad flag")), want: ExitCodeUsage},
+		{err: &login.Error{Kind: login.ErrSealed, Err: errors.New("Vault is sealed")}, want: ExitCodeSealed},
+		{err: fmt.Errorf("wrapped: %w", &login.Error{Kind: login.ErrUnavailable, Err: errors.New("connection refused")}), want: ExitCodeUnavailable},
+		{err: &login.Error{Kind: login.ErrTimeout, Err: context.DeadlineExceeded}, want: ExitCodeTimeout},
+		{err: context.DeadlineExceeded, want: ExitCodeTimeout},
+		{err: context.Canceled, want: ExitCodeCanceled},
+	}
+	for _, tt := range exitTests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Fatalf("%v: got %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestMarkUsageErrors(t *testing.T) {
+	root := &cobra.Command{Use: "root"}
+	sub := &cobra.Command{
+		Use:  "sub",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+	root.AddCommand(sub)
+	markUsageErrors(root)
+
+	for _, args := range [][]string{{"sub"}, {"sub", "--unknown", "x"}} {
+		root.SetArgs(args)
+		root.SetOutput(ioutil.Discard)
+		if err := root.Execute(); exitCode(err) != ExitCodeUsage {
+			t.Fatalf("%v: got %v, want a usage error", args, err)
+		}
+	}
+}
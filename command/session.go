@@ -0,0 +1,123 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/vault/api"
+	vt "github.com/mauromedda/vauth/command/token"
+	"github.com/mauromedda/vauth/pkg/login"
+	"github.com/spf13/cobra"
+)
+
+// errNoToken is returned by the commands requiring a stored token when there
+// is none.
+var errNoToken = fmt.Errorf("no Vault token available, run \"vauth login\" first")
+
+// storedToken returns the token stored by the token helper or, if missing,
+// the VAULT_TOKEN environment variable. The token of the last vauth login
+// wins over a possibly stale VAULT_TOKEN.
+func storedToken() (string, error) {
+	tokenHelper := vt.InternalTokenHelper{}
+	token, err := tokenHelper.Get()
+	if err != nil {
+		return "", fmt.Errorf("error reading the stored token: %s", err)
+	}
+	if token == "" {
+		token = os.Getenv(api.EnvVaultToken)
+	}
+	return token, nil
+}
+
+// sessionClient returns a Vault client bound to ctx and authenticated with
+// the stored token.
+func sessionClient(ctx context.Context) (*api.Client, error) {
+	token, err := storedToken()
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, errNoToken
+	}
+	client, err := login.NewClientWithLogger(ctx, nil, logger)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+	return client, nil
+}
+
+// profileClient returns a Vault client bound to ctx and authenticated with the
+// stored token or, when it is missing or no longer valid, with the token of a
+// fresh login using the profile name. The new token gets stored.
+func profileClient(ctx context.Context, name string) (*api.Client, error) {
+	client, err := sessionClient(ctx)
+	if err != nil && err != errNoToken {
+		return nil, err
+	}
+	if err == nil {
+		if _, err = client.Auth().Token().LookupSelf(); err == nil {
+			return client, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("the stored Vault token is missing or expired and no profile is provided: %s", err)
+	}
+
+	p, err := loadProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := p.loginOptions()
+	if err != nil {
+		return nil, err
+	}
+	tokenHelper := &vt.InternalTokenHelper{}
+	opts.TokenSink = tokenHelper
+	opts.Logger = logger
+	sec, err := login.Authenticate(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	token, err := sec.TokenID()
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		if client, err = login.NewClientWithLogger(ctx, nil, logger); err != nil {
+			return nil, err
+		}
+	}
+	client.SetToken(token)
+	return client, nil
+}
+
+// sessionToken returns the token of the current session: the one obtained by
+// a fresh login when the --method flag of cmd is set, otherwise the stored
+// one.
+func sessionToken(ctx context.Context, cmd *cobra.Command, args []string) (string, error) {
+	if !cmd.Flags().Changed("method") {
+		token, err := storedToken()
+		if err != nil {
+			return "", err
+		}
+		if token == "" {
+			return "", errNoToken
+		}
+		return token, nil
+	}
+
+	opts, err := loginOptions(cmd, args)
+	if err != nil {
+		return "", err
+	}
+	sec, err := login.Authenticate(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	return sec.TokenID()
+}
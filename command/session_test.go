@@ -0,0 +1,122 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	vt "github.com/mauromedda/vauth/command/token"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+	"github.com/mitchellh/go-homedir"
+)
+
+// withVault points VAULT_ADDR and VAULT_TOKEN to the fake server and returns
+// a function restoring them.
+func withVault(vault *vaulttest.Server) func() {
+	os.Setenv("VAULT_ADDR", vault.URL)
+	os.Setenv("VAULT_TOKEN", vault.RootToken)
+	return func() {
+		os.Setenv("VAULT_ADDR", "")
+		os.Setenv("VAULT_TOKEN", "")
+	}
+}
+
+// withHome points HOME to a new temporary directory and returns it with a
+// function restoring HOME.
+func withHome(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "vauth-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	homedir.DisableCache = true
+	return dir, func() {
+		os.Setenv("HOME", home)
+		// Dir caches the temporary HOME even with DisableCache set
+		homedir.DisableCache = false
+		homedir.Reset()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestProfileClient(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddUser("userpass", "ci", "secret", "default")
+	defer withVault(vault)()
+	os.Setenv("VAULT_TOKEN", "")
+	home, restore := withHome(t)
+	defer restore()
+
+	os.MkdirAll(filepath.Join(home, ".vauth"), 0700)
+	err := ioutil.WriteFile(filepath.Join(home, ".vauth", "profiles.json"), []byte(`{
+  "profiles": {
+    "ci": {"method": "userpass", "args": ["username=ci", "password=secret"]}
+  }
+}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenHelper := vt.InternalTokenHelper{}
+	ctx := context.Background()
+
+	if _, err := profileClient(ctx, ""); err == nil {
+		t.Fatal("expected an error without token and profile")
+	}
+	if _, err := profileClient(ctx, "missing"); err == nil {
+		t.Fatal("expected an error with a missing profile")
+	}
+
+	// Missing token: log in with the profile and store the token
+	client, err := profileClient(ctx, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := tokenHelper.Get()
+	if stored == "" || stored != client.Token() {
+		t.Fatalf("expected the new token %q to be stored, got %q", client.Token(), stored)
+	}
+
+	// Valid token: reuse it
+	client, err = profileClient(ctx, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token() != stored {
+		t.Fatalf("got token %q, want the stored %q", client.Token(), stored)
+	}
+
+	// Expired token: log in again
+	tokenHelper.Store("s.expired")
+	client, err = profileClient(ctx, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token() == "s.expired" {
+		t.Fatal("expected a new token")
+	}
+}
+
+func TestStoredToken(t *testing.T) {
+	_, restore := withHome(t)
+	defer restore()
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Setenv("VAULT_TOKEN", "env-token")
+
+	// Without a stored token VAULT_TOKEN is used
+	if token, err := storedToken(); err != nil || token != "env-token" {
+		t.Fatalf("got %q, %v, want %q", token, err, "env-token")
+	}
+	// The token of the last login wins over VAULT_TOKEN
+	tokenHelper := vt.InternalTokenHelper{}
+	if err := tokenHelper.Store("stored-token"); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := storedToken(); err != nil || token != "stored-token" {
+		t.Fatalf("got %q, %v, want %q", token, err, "stored-token")
+	}
+}
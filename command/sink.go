@@ -0,0 +1,182 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	vt "github.com/mauromedda/vauth/command/token"
+	"github.com/mauromedda/vauth/pkg/login"
+	"github.com/spf13/cobra"
+)
+
+// readKeyPair reads the key pair file written by sink keygen.
+func readKeyPair(path string) (*vt.KeyPair, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys vt.KeyPair
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("error decoding the private key %s: %s", path, err)
+	}
+	if len(keys.Curve25519PrivateKey) == 0 {
+		return nil, fmt.Errorf("no curve25519_private_key in %s", path)
+	}
+	return &keys, nil
+}
+
+// unwrapToken returns the token wrapped in the JSON wrapping info written by
+// a sink with wrap_ttl.
+func unwrapToken(ctx context.Context, wrapInfo string) (string, error) {
+	var info api.SecretWrapInfo
+	if err := json.Unmarshal([]byte(wrapInfo), &info); err != nil || info.Token == "" {
+		return "", fmt.Errorf("the sink does not hold a wrapping token")
+	}
+	client, err := login.NewClientWithLogger(ctx, nil, logger)
+	if err != nil {
+		return "", err
+	}
+	client.SetToken("")
+	secret, err := client.Logical().Unwrap(info.Token)
+	if err != nil {
+		return "", fmt.Errorf("error unwrapping the token: %s", err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("error unwrapping the token: empty response")
+	}
+	if token, ok := secret.Data["token"].(string); ok && token != "" {
+		return token, nil
+	}
+	if secret.Auth != nil && secret.Auth.ClientToken != "" {
+		return secret.Auth.ClientToken, nil
+	}
+	return "", fmt.Errorf("no token in the wrapped response")
+}
+
+func init() {
+	rootCmd.AddCommand(sinkCmd)
+	sinkCmd.AddCommand(sinkKeygenCmd)
+	sinkCmd.AddCommand(sinkDecryptCmd)
+	sinkKeygenCmd.Flags().String("public-key", "", "File of the public key, the dh_path of the sink")
+	sinkKeygenCmd.Flags().String("private-key", "", "File of the key pair, readable only by the user")
+	sinkDecryptCmd.Flags().String("private-key", "", "File of the key pair written by sink keygen")
+	sinkDecryptCmd.Flags().String("aad", "", "Additional authenticated data, the aad of the sink")
+	sinkDecryptCmd.Flags().Bool("unwrap", false, "Unwrap the wrapping token written by a sink with wrap_ttl")
+}
+
+var sinkCmd = &cobra.Command{
+	Use:   "sink",
+	Short: "Consume the tokens written by the agent sinks",
+}
+
+var sinkKeygenCmd = &cobra.Command{
+	Use:   "keygen --public-key <path> --private-key <path>",
+	Short: "Generate the curve25519 key pair of a sink consumer",
+	Long: `This subcommand generates the curve25519 key pair of the consumer of a sink with
+dh_type=curve25519. The public key file is the dh_path of the sink, the private
+key file is read by sink decrypt.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		publicPath, err := cmd.Flags().GetString("public-key")
+		if err != nil {
+			return err
+		}
+		privatePath, err := cmd.Flags().GetString("private-key")
+		if err != nil {
+			return err
+		}
+		if publicPath == "" || privatePath == "" {
+			return fmt.Errorf("--public-key and --private-key are required")
+		}
+		cmd.SilenceUsage = true
+
+		keys, err := vt.GenerateKeyPair()
+		if err != nil {
+			return err
+		}
+		public, err := json.Marshal(vt.PublicKeyInfo{Curve25519PublicKey: keys.Curve25519PublicKey})
+		if err != nil {
+			return err
+		}
+		private, err := json.Marshal(keys)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(privatePath, private, 0600); err != nil {
+			return err
+		}
+		return writeFileAtomic(publicPath, public, 0644)
+	},
+}
+
+var sinkDecryptCmd = &cobra.Command{
+	Use:   "decrypt [--private-key <path> [--aad A]] [--unwrap] [file]",
+	Short: "Print the token of a sink encrypted with dh_type=curve25519",
+	Long: `This subcommand decrypts the envelope written by a sink with dh_type=curve25519,
+read from file or stdin, and prints the token. The envelope format is the one of
+the Vault Agent dh sinks.
+
+With --unwrap the wrapping token written by a sink with wrap_ttl is unwrapped
+and the resulting token printed; --private-key is optional when the sink is not
+encrypted.
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		privatePath, err := cmd.Flags().GetString("private-key")
+		if err != nil {
+			return err
+		}
+		aad, err := cmd.Flags().GetString("aad")
+		if err != nil {
+			return err
+		}
+		unwrap, err := cmd.Flags().GetBool("unwrap")
+		if err != nil {
+			return err
+		}
+		if privatePath == "" && !unwrap {
+			return fmt.Errorf("--private-key is required")
+		}
+		cmd.SilenceUsage = true
+
+		var in io.Reader = os.Stdin
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		envelope, err := ioutil.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		// Sinks with only wrap_ttl are not encrypted
+		token := string(envelope)
+		if privatePath != "" {
+			keys, err := readKeyPair(privatePath)
+			if err != nil {
+				return err
+			}
+			if token, err = vt.Decrypt(envelope, keys.Curve25519PrivateKey, aad); err != nil {
+				return err
+			}
+		}
+		if unwrap {
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			if token, err = unwrapToken(ctx, strings.TrimSpace(token)); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(os.Stdout, token)
+		return nil
+	},
+}
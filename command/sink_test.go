@@ -0,0 +1,93 @@
+package command
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	vt "github.com/mauromedda/vauth/command/token"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestParseSinkOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vauth-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys, err := vt.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _ := json.Marshal(vt.PublicKeyInfo{Curve25519PublicKey: keys.Curve25519PublicKey})
+	dhPath := filepath.Join(dir, "consumer.json")
+	if err := ioutil.WriteFile(dhPath, public, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := parseSink("file:/vault/token,wrap_ttl=5m,dh_type=curve25519,dh_path="+dhPath+",aad=app", 0640, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, ok := sink.(*vt.WrappedSink)
+	if !ok || wrapped.TTL.Minutes() != 5 {
+		t.Fatalf("expected a wrapped sink, got %#v", sink)
+	}
+	encrypted, ok := wrapped.Sink.(*vt.EncryptedSink)
+	if !ok || encrypted.AAD != "app" {
+		t.Fatalf("expected an encrypted sink, got %#v", wrapped.Sink)
+	}
+	if file, ok := encrypted.Sink.(*vt.FileSink); !ok || file.Path != "/vault/token" || file.Mode != 0640 {
+		t.Fatalf("expected a file sink, got %#v", encrypted.Sink)
+	}
+
+	for _, value := range []string{
+		"file:/vault/token,wrap_ttl=never",
+		"file:/vault/token,dh_type=curve25519",
+		"file:/vault/token,dh_type=p256,dh_path=" + dhPath,
+		"file:/vault/token,dh_path=" + dhPath,
+		"file:/vault/token,mode=0600",
+		"file:/vault/token,wrap_ttl",
+	} {
+		if _, err := parseSink(value, 0640, nil); err == nil {
+			t.Fatalf("expected an error parsing %q", value)
+		}
+	}
+}
+
+func TestUnwrapToken(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	defer withVault(vault)()
+	client, err := vault.Client("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := vault.CreateToken("app")
+
+	next := &vt.FileSink{Path: filepath.Join(os.TempDir(), "vauth-wrapped-token"), Mode: 0600}
+	defer os.Remove(next.Path)
+	sink, err := parseSink("file:"+next.Path+",wrap_ttl=1m", 0600, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Store(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	wrapInfo, err := ioutil.ReadFile(next.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := unwrapToken(rootCtx, string(wrapInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != token.ID {
+		t.Fatalf("got %v, want %v", got, token.ID)
+	}
+	if _, err := unwrapToken(rootCtx, token.ID); err == nil {
+		t.Fatal("expected an error unwrapping a plain token")
+	}
+}
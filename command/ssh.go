@@ -0,0 +1,269 @@
+package command
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// sshSignRequest is a public key signing of the ssh sign sub-command.
+type sshSignRequest struct {
+	mount      string
+	role       string
+	publicKey  string
+	principals []string
+	ttl        string
+	extensions []string
+	certType   string
+}
+
+// sshCertPath returns the path of the certificate of the public key at path,
+// e.g. ~/.ssh/id_ed25519-cert.pub for ~/.ssh/id_ed25519.pub, where ssh looks
+// for it.
+func sshCertPath(publicKeyPath string) string {
+	return strings.TrimSuffix(publicKeyPath, ".pub") + "-cert.pub"
+}
+
+// parseExtensions turns the name or name=value extensions into the map
+// expected by Vault.
+func parseExtensions(extensions []string) map[string]string {
+	m := make(map[string]string, len(extensions))
+	for _, e := range extensions {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 {
+			m[kv[0]] = kv[1]
+		} else {
+			m[kv[0]] = ""
+		}
+	}
+	return m
+}
+
+// signPublicKey signs the public key with the SSH CA through
+// <mount>/sign/<role> and returns the certificate.
+func signPublicKey(client *api.Client, r sshSignRequest) (*ssh.Certificate, []byte, error) {
+	data := map[string]interface{}{
+		"public_key": r.publicKey,
+		"cert_type":  r.certType,
+	}
+	if len(r.principals) > 0 {
+		data["valid_principals"] = strings.Join(r.principals, ",")
+	}
+	if r.ttl != "" {
+		data["ttl"] = r.ttl
+	}
+	if len(r.extensions) > 0 {
+		data["extensions"] = parseExtensions(r.extensions)
+	}
+	secret, err := client.SSHWithMountPoint(strings.Trim(r.mount, "/")).SignKey(r.role, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil, fmt.Errorf("empty response signing the public key")
+	}
+	signed, _ := secret.Data["signed_key"].(string)
+	if signed == "" {
+		return nil, nil, fmt.Errorf("no signed_key in the response")
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signed))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing the signed key: %s", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, nil, fmt.Errorf("the signed key is not a certificate")
+	}
+	return cert, []byte(strings.TrimSpace(signed) + "\n"), nil
+}
+
+// readPrivateKey reads the private key at path, prompting for the passphrase
+// of the encrypted PEM keys.
+func readPrivateKey(path string) (interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.ParseRawPrivateKey(b)
+	if err == nil || !strings.Contains(err.Error(), "encrypted") {
+		return key, err
+	}
+	if strings.Contains(string(b), "OPENSSH PRIVATE KEY") || !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("cannot decrypt %s, run ssh-add %s to load the key with its certificate", path, path)
+	}
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", path)
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	key, err = ssh.ParseRawPrivateKeyWithPassphrase(b, passphrase)
+	if err == x509.IncorrectPasswordError {
+		return nil, fmt.Errorf("incorrect passphrase for %s", path)
+	}
+	return key, err
+}
+
+// addToAgent loads the private key with its certificate into the ssh-agent
+// listening on the $SSH_AUTH_SOCK, until the certificate expires.
+func addToAgent(privateKey interface{}, cert *ssh.Certificate, now time.Time) error {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return fmt.Errorf("SSH_AUTH_SOCK not set, no ssh-agent running")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("error connecting to the ssh-agent: %s", err)
+	}
+	defer conn.Close()
+
+	added := sshagent.AddedKey{
+		PrivateKey:  privateKey,
+		Certificate: cert,
+		Comment:     fmt.Sprintf("vauth %s", cert.KeyId),
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		lifetime := time.Unix(int64(cert.ValidBefore), 0).Sub(now)
+		if lifetime <= 0 {
+			return fmt.Errorf("the certificate is already expired")
+		}
+		added.LifetimeSecs = uint32(lifetime.Seconds())
+	}
+	if err := sshagent.NewClient(conn).Add(added); err != nil {
+		return fmt.Errorf("error adding the key to the ssh-agent: %s", err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(sshCmd)
+	sshCmd.AddCommand(sshSignCmd)
+	sshSignCmd.Flags().String("mount", "ssh", "Path where the SSH secrets engine is mounted")
+	sshSignCmd.Flags().String("role", "", "Role of the SSH secrets engine")
+	sshSignCmd.Flags().String("public-key", "~/.ssh/id_ed25519.pub", "Public key to sign")
+	sshSignCmd.Flags().StringSlice("principals", nil, "Valid principals of the certificate, the role default if empty")
+	sshSignCmd.Flags().String("ttl", "", "TTL of the certificate (e.g. 8h), the role default if empty")
+	sshSignCmd.Flags().StringSlice("extensions", nil, "Extensions of the certificate, e.g. permit-pty,permit-port-forwarding")
+	sshSignCmd.Flags().String("cert-type", "user", "Type of the certificate: user or host")
+	sshSignCmd.Flags().String("out", "", "Certificate file, <key>-cert.pub next to the public key if empty")
+	sshSignCmd.Flags().Bool("agent", false, "Load the private key and the certificate into the running ssh-agent")
+	sshSignCmd.Flags().String("profile", os.Getenv("VAUTH_PROFILE"), "Login profile used when the stored token is missing or expired")
+}
+
+var sshCmd = &cobra.Command{
+	Use:   "ssh",
+	Short: "Interact with the Vault SSH secrets engine",
+}
+
+var sshSignCmd = &cobra.Command{
+	Use:   "sign --role R [--public-key ~/.ssh/id_ed25519.pub] [--principals P] [--agent]",
+	Short: "Sign an SSH public key with the Vault SSH CA",
+	Long: `This subcommand signs a public key through <mount>/sign/<role> and writes the
+certificate next to it, e.g. ~/.ssh/id_ed25519-cert.pub, where ssh finds it:
+
+    $ vauth ssh sign --role engineer --public-key ~/.ssh/id_ed25519.pub --principals ubuntu --ttl 8h
+
+With --agent the private key, read from the public key path without .pub, is
+loaded with the certificate into the ssh-agent until the certificate expires.
+
+The stored token is used; when it is missing or expired vauth logs in first
+with the --profile (or $VAUTH_PROFILE) defined in ~/.vauth/profiles.json.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var r sshSignRequest
+		var err error
+		if r.mount, err = cmd.Flags().GetString("mount"); err != nil {
+			return err
+		}
+		if r.role, err = cmd.Flags().GetString("role"); err != nil {
+			return err
+		}
+		publicKeyPath, err := cmd.Flags().GetString("public-key")
+		if err != nil {
+			return err
+		}
+		if r.principals, err = cmd.Flags().GetStringSlice("principals"); err != nil {
+			return err
+		}
+		if r.ttl, err = cmd.Flags().GetString("ttl"); err != nil {
+			return err
+		}
+		if r.extensions, err = cmd.Flags().GetStringSlice("extensions"); err != nil {
+			return err
+		}
+		if r.certType, err = cmd.Flags().GetString("cert-type"); err != nil {
+			return err
+		}
+		out, err := cmd.Flags().GetString("out")
+		if err != nil {
+			return err
+		}
+		loadAgent, err := cmd.Flags().GetBool("agent")
+		if err != nil {
+			return err
+		}
+		profileName, err := cmd.Flags().GetString("profile")
+		if err != nil {
+			return err
+		}
+		if r.role == "" {
+			return fmt.Errorf("--role is required")
+		}
+		if publicKeyPath, err = homedir.Expand(publicKeyPath); err != nil {
+			return err
+		}
+		if out == "" {
+			out = sshCertPath(publicKeyPath)
+		}
+		publicKey, err := ioutil.ReadFile(publicKeyPath)
+		if err != nil {
+			return err
+		}
+		r.publicKey = string(publicKey)
+		cmd.SilenceUsage = true
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := profileClient(ctx, profileName)
+		if err != nil {
+			return err
+		}
+		cert, signed, err := signPublicKey(client, r)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(out, signed, 0644); err != nil {
+			return err
+		}
+		if cert.ValidBefore == ssh.CertTimeInfinity {
+			fmt.Fprintf(os.Stderr, "Certificate written to %s\n", out)
+		} else {
+			fmt.Fprintf(os.Stderr, "Certificate written to %s, valid until %s\n", out,
+				time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339))
+		}
+		if !loadAgent {
+			return nil
+		}
+
+		privateKey, err := readPrivateKey(strings.TrimSuffix(publicKeyPath, ".pub"))
+		if err != nil {
+			return err
+		}
+		if err := addToAgent(privateKey, cert, time.Now()); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Key and certificate added to the ssh-agent")
+		return nil
+	},
+}
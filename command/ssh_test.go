@@ -0,0 +1,173 @@
+package command
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+// handleSSHSign serves ssh/sign/<role> signing the public keys with a new CA
+// key. It records the last request body in body.
+func handleSSHSign(t *testing.T, vault *vaulttest.Server, body *map[string]interface{}) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.HandleFunc("/v1/ssh/sign/engineer", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(body)
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte((*body)["public_key"].(string)))
+		if err != nil {
+			vaulttest.RespondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		principals, _ := (*body)["valid_principals"].(string)
+		cert := &ssh.Certificate{
+			Key:             pub,
+			CertType:        ssh.UserCert,
+			KeyId:           "vault-engineer",
+			ValidPrincipals: strings.Split(principals, ","),
+			ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			vaulttest.RespondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		vaulttest.RespondData(w, map[string]interface{}{
+			"serial_number": "1",
+			"signed_key":    string(ssh.MarshalAuthorizedKey(cert)),
+		})
+	})
+}
+
+// writeSSHKey writes a new ECDSA key pair as dir/id_ecdsa and dir/id_ecdsa.pub.
+func writeSSHKey(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "id_ecdsa")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(pub), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSSHCertPath(t *testing.T) {
+	if got, want := sshCertPath("/home/u/.ssh/id_ed25519.pub"), "/home/u/.ssh/id_ed25519-cert.pub"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestSignPublicKey(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	var body map[string]interface{}
+	handleSSHSign(t, vault, &body)
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "vauth-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath := writeSSHKey(t, dir)
+	publicKey, _ := ioutil.ReadFile(keyPath + ".pub")
+
+	cert, signed, err := signPublicKey(client, sshSignRequest{
+		mount:      "ssh",
+		role:       "engineer",
+		publicKey:  string(publicKey),
+		principals: []string{"ubuntu", "admin"},
+		ttl:        "1h",
+		extensions: []string{"permit-pty", "login@example.com=admin"},
+		certType:   "user",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body["valid_principals"], "ubuntu,admin"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := body["ttl"], "1h"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	extensions, _ := body["extensions"].(map[string]interface{})
+	if extensions["permit-pty"] != "" || extensions["login@example.com"] != "admin" {
+		t.Fatalf("unexpected extensions %v", body["extensions"])
+	}
+	if len(cert.ValidPrincipals) != 2 || !strings.HasPrefix(string(signed), "ecdsa-sha2-nistp256-cert-v01@openssh.com ") {
+		t.Fatalf("unexpected certificate %v, %s", cert.ValidPrincipals, signed)
+	}
+
+	// Load the key and the certificate into an ssh-agent
+	keyring := sshagent.NewKeyring()
+	socket := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go sshagent.ServeAgent(keyring, conn)
+		}
+	}()
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Setenv("SSH_AUTH_SOCK", socket)
+
+	privateKey, err := readPrivateKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addToAgent(privateKey, cert, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Type() != cert.Type() {
+		t.Fatalf("expected the certificate in the agent, got %v", keys)
+	}
+
+	if err := addToAgent(privateKey, cert, time.Now().Add(2*time.Hour)); err == nil {
+		t.Fatal("expected an error adding an expired certificate")
+	}
+}
@@ -0,0 +1,309 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// templateRenderer renders text/template templates reading the secrets from
+// Vault. The secrets read are cached until refresh renews or drops them.
+type templateRenderer struct {
+	client   *api.Client
+	secrets  map[string]*api.Secret
+	interval time.Duration
+}
+
+// newTemplateRenderer returns a renderer reading the secrets with client and
+// reading again the static ones every interval in watch mode.
+func newTemplateRenderer(client *api.Client, interval time.Duration) *templateRenderer {
+	return &templateRenderer{
+		client:   client,
+		secrets:  make(map[string]*api.Secret),
+		interval: interval,
+	}
+}
+
+// funcs returns the functions available to the templates.
+func (r *templateRenderer) funcs() template.FuncMap {
+	return template.FuncMap{
+		"secret": r.secret,
+		"env":    os.Getenv,
+		"base64": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"base64Decode": func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return string(b), err
+		},
+	}
+}
+
+// secret returns the field of the secret at path or, without field, the
+// secret data. The data of the KV v2 secrets is unwrapped.
+func (r *templateRenderer) secret(path string, field ...string) (interface{}, error) {
+	if len(field) > 1 {
+		return nil, fmt.Errorf("secret accepts a single field, got %d", len(field))
+	}
+	s, ok := r.secrets[path]
+	if !ok {
+		var err error
+		if s, err = r.client.Logical().Read(path); err != nil {
+			return nil, err
+		}
+		if s == nil || s.Data == nil {
+			return nil, fmt.Errorf("no value found at %s", path)
+		}
+		r.secrets[path] = s
+	}
+	data := s.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+	if len(field) == 0 {
+		return data, nil
+	}
+	v, ok := data[field[0]]
+	if !ok {
+		return nil, fmt.Errorf("%s field not found at %s", field[0], path)
+	}
+	return formatValue(v), nil
+}
+
+// render renders the template text.
+func (r *templateRenderer) render(name, text string) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(r.funcs()).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// refresh renews the leases of the cached secrets. The secrets whose lease is
+// not renewable or reached its max TTL, and the static ones, are dropped to be
+// read again, possibly rotated, by the next render.
+func (r *templateRenderer) refresh() {
+	for path, s := range r.secrets {
+		if s.LeaseID == "" || !s.Renewable {
+			delete(r.secrets, path)
+			continue
+		}
+		renewed, err := r.client.Sys().Renew(s.LeaseID, s.LeaseDuration)
+		if err != nil || renewed == nil || renewed.LeaseDuration < s.LeaseDuration {
+			delete(r.secrets, path)
+			continue
+		}
+		s.LeaseDuration = renewed.LeaseDuration
+	}
+}
+
+// nextRefresh returns when the cached secrets need a refresh: at two thirds
+// of the shortest lease, and at least every interval.
+func (r *templateRenderer) nextRefresh() time.Duration {
+	next := r.interval
+	for _, s := range r.secrets {
+		if s.LeaseDuration <= 0 {
+			continue
+		}
+		if d := time.Duration(s.LeaseDuration) * time.Second * 2 / 3; d < next {
+			next = d
+		}
+	}
+	return next
+}
+
+// templateJob renders a template file to its destination.
+type templateJob struct {
+	in     string
+	out    string
+	mode   os.FileMode
+	reload string
+	stdout io.Writer
+	stderr io.Writer
+
+	rendered []byte
+}
+
+// run renders the template with r and writes it when the result changed. It
+// reports whether the output was written.
+func (j *templateJob) run(r *templateRenderer) (bool, error) {
+	text, err := ioutil.ReadFile(j.in)
+	if err != nil {
+		return false, err
+	}
+	out, err := r.render(j.in, string(text))
+	if err != nil {
+		return false, err
+	}
+	if j.rendered != nil && bytes.Equal(out, j.rendered) {
+		return false, nil
+	}
+	if j.out == "" {
+		_, err = j.stdout.Write(out)
+	} else {
+		err = writeFileAtomic(j.out, out, j.mode)
+	}
+	if err != nil {
+		return false, err
+	}
+	j.rendered = out
+	return true, nil
+}
+
+// runReload runs the reload command, if any.
+func (j *templateJob) runReload(ctx context.Context) error {
+	return runReload(ctx, j.reload, j.stdout, j.stderr)
+}
+
+// watch renders the template again whenever the secrets need a refresh,
+// running the reload command after each change, until ctx is done. newClient
+// returns the client used after an error, e.g. when the token expired.
+func (j *templateJob) watch(ctx context.Context, r *templateRenderer, newClient func() (*api.Client, error)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.nextRefresh()):
+		}
+
+		r.refresh()
+		changed, err := j.run(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warn("template rendering failed, starting over with a new client", "template", j.in, "error", err)
+			// Start over with a fresh client and no cached secrets
+			if client, err := newClient(); err == nil {
+				r.client = client
+			}
+			r.secrets = make(map[string]*api.Secret)
+			continue
+		}
+		if changed {
+			if err := j.runReload(ctx); err != nil {
+				logger.Error("reload command failed", "template", j.in, "error", err)
+			}
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(templateCmd)
+	templateCmd.Flags().String("in", "", "Template file")
+	templateCmd.Flags().String("out", "", "Rendered file, stdout if empty")
+	templateCmd.Flags().String("mode", "0600", "Permissions of the rendered file")
+	templateCmd.Flags().Bool("watch", false, "Render again when the leases are renewed or the secrets rotated")
+	templateCmd.Flags().Duration("interval", 5*time.Minute, "Maximum interval between two reads of the secrets in watch mode")
+	templateCmd.Flags().String("reload", "", "Shell command run after the rendered file changed in watch mode")
+	templateCmd.Flags().String("profile", os.Getenv("VAUTH_PROFILE"), "Login profile used when the stored token is missing or expired")
+}
+
+var templateCmd = &cobra.Command{
+	Use:   "template --in app.tmpl [--out app.conf] [--watch [--reload CMD]]",
+	Short: "Render a template with secrets read from Vault",
+	Long: `This subcommand renders a Go text/template file with the secrets read from
+Vault, e.g.
+
+    password = {{ secret "kv/data/app" "password" }}
+    home     = {{ env "HOME" }}
+    auth     = {{ printf "%s:%s" "app" (secret "kv/data/app" "password") | base64 }}
+
+The secret function reads the API path, so KV v2 secrets need the data/ path;
+without field it returns the secret data. The env, base64 and base64Decode
+functions are also available.
+
+The stored token is used; when it is missing or expired vauth logs in first
+with the --profile (or $VAUTH_PROFILE) defined in ~/.vauth/profiles.json.
+
+With --watch the leases of the secrets are renewed and the secrets are read
+again when rotated, at most every --interval; the file is rendered again and
+the --reload command run whenever its content changes.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, err := cmd.Flags().GetString("in")
+		if err != nil {
+			return err
+		}
+		out, err := cmd.Flags().GetString("out")
+		if err != nil {
+			return err
+		}
+		modeFlag, err := cmd.Flags().GetString("mode")
+		if err != nil {
+			return err
+		}
+		watch, err := cmd.Flags().GetBool("watch")
+		if err != nil {
+			return err
+		}
+		interval, err := cmd.Flags().GetDuration("interval")
+		if err != nil {
+			return err
+		}
+		reload, err := cmd.Flags().GetString("reload")
+		if err != nil {
+			return err
+		}
+		profileName, err := cmd.Flags().GetString("profile")
+		if err != nil {
+			return err
+		}
+		if in == "" {
+			return fmt.Errorf("missing --in template file")
+		}
+		if watch && out == "" {
+			return fmt.Errorf("--watch requires --out")
+		}
+		if interval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+		mode, err := strconv.ParseUint(modeFlag, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid --mode %q", modeFlag)
+		}
+		cmd.SilenceUsage = true
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := profileClient(ctx, profileName)
+		if err != nil {
+			return err
+		}
+		r := newTemplateRenderer(client, interval)
+		j := &templateJob{
+			in:     in,
+			out:    out,
+			mode:   os.FileMode(mode),
+			reload: reload,
+			stdout: os.Stdout,
+			stderr: os.Stderr,
+		}
+		if _, err := j.run(r); err != nil {
+			return err
+		}
+		if !watch {
+			return nil
+		}
+		if err := j.runReload(ctx); err != nil {
+			logger.Error("reload command failed", "template", j.in, "error", err)
+		}
+		return j.watch(ctx, r, func() (*api.Client, error) {
+			return profileClient(ctx, profileName)
+		})
+	},
+}
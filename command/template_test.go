@@ -0,0 +1,186 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestTemplateRender(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.EnableKV("kv", 2)
+	vault.EnableKV("legacy", 1)
+	vault.SetSecret("kv", "app", map[string]interface{}{"password": "s3cr3t", "port": 5432})
+	vault.SetSecret("legacy", "app", map[string]interface{}{"user": "app"})
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("VAUTH_TEST_ENV", "prod")
+	defer os.Unsetenv("VAUTH_TEST_ENV")
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`{{ secret "kv/data/app" "password" }}`, "s3cr3t"},
+		{`{{ secret "kv/data/app" "port" }}`, "5432"},
+		{`{{ with secret "kv/data/app" }}{{ .password }}{{ end }}`, "s3cr3t"},
+		{`{{ secret "legacy/app" "user" }}`, "app"},
+		{`{{ env "VAUTH_TEST_ENV" }}`, "prod"},
+		{`{{ secret "legacy/app" "user" | base64 }}`, "YXBw"},
+		{`{{ base64Decode "YXBw" }}`, "app"},
+	}
+	r := newTemplateRenderer(client, time.Minute)
+	for _, tt := range tests {
+		got, err := r.render("test", tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Fatalf("got %v, want %v", string(got), tt.want)
+		}
+	}
+
+	for _, text := range []string{
+		`{{ secret "kv/data/missing" "password" }}`,
+		`{{ secret "kv/data/app" "missing" }}`,
+		`{{ secret "kv/data/app" "password" "port" }}`,
+		`{{ unknown }}`,
+	} {
+		if _, err := r.render("test", text); err == nil {
+			t.Fatalf("expected an error rendering %s", text)
+		}
+	}
+}
+
+func TestTemplateRefresh(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.EnableKV("kv", 1)
+	vault.SetSecret("kv", "app", map[string]interface{}{"password": "v1"})
+	renewals := 0
+	vault.HandleFunc("/v1/database/creds/app", func(w http.ResponseWriter, r *http.Request) {
+		vaulttest.RespondJSON(w, http.StatusOK, map[string]interface{}{
+			"lease_id":       "database/creds/app/1",
+			"lease_duration": 3600,
+			"renewable":      true,
+			"data":           map[string]interface{}{"password": "dynamic"},
+		})
+	})
+	vault.HandleFunc("/v1/sys/leases/renew", func(w http.ResponseWriter, r *http.Request) {
+		renewals++
+		// The second renewal hits the max TTL
+		duration := 3600
+		if renewals > 1 {
+			duration = 60
+		}
+		vaulttest.RespondJSON(w, http.StatusOK, map[string]interface{}{
+			"lease_id":       "database/creds/app/1",
+			"lease_duration": duration,
+			"renewable":      true,
+		})
+	})
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTemplateRenderer(client, time.Hour)
+	if _, err := r.render("test", `{{ secret "kv/app" "password" }} {{ secret "database/creds/app" "password" }}`); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.nextRefresh(), 40*time.Minute; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// The static secret is dropped, the lease renewed
+	r.refresh()
+	if _, ok := r.secrets["kv/app"]; ok {
+		t.Fatal("expected the static secret to be dropped")
+	}
+	if _, ok := r.secrets["database/creds/app"]; !ok {
+		t.Fatal("expected the renewed secret to be kept")
+	}
+
+	// The lease reaching its max TTL is dropped to be rotated
+	r.refresh()
+	if _, ok := r.secrets["database/creds/app"]; ok {
+		t.Fatal("expected the expiring secret to be dropped")
+	}
+	if got, want := r.nextRefresh(), time.Hour; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestTemplateJob(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.EnableKV("kv", 1)
+	vault.SetSecret("kv", "app", map[string]interface{}{"password": "v1"})
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "vauth-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "app.tmpl")
+	if err := ioutil.WriteFile(in, []byte(`password={{ secret "kv/app" "password" }}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	j := &templateJob{
+		in:     in,
+		out:    filepath.Join(dir, "app.conf"),
+		mode:   0640,
+		reload: "echo reloaded >> " + filepath.Join(dir, "reload.log"),
+		stdout: ioutil.Discard,
+		stderr: &stderr,
+	}
+	r := newTemplateRenderer(client, time.Hour)
+	for _, want := range []bool{true, false} {
+		changed, err := j.run(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != want {
+			t.Fatalf("got %v, want %v", changed, want)
+		}
+	}
+	b, err := ioutil.ReadFile(j.out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "password=v1"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if fi, err := os.Stat(j.out); err != nil || fi.Mode().Perm() != 0640 {
+		t.Fatalf("unexpected rendered file mode %v, %v", fi.Mode(), err)
+	}
+
+	// A rotated secret is rendered again
+	vault.SetSecret("kv", "app", map[string]interface{}{"password": "v2"})
+	r.refresh()
+	if changed, err := j.run(r); err != nil || !changed {
+		t.Fatalf("expected the file to change, got %v, %v", changed, err)
+	}
+	if err := j.runReload(rootCtx); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(dir, "reload.log"))
+	if err != nil || strings.TrimSpace(string(b)) != "reloaded" {
+		t.Fatalf("unexpected reload log %q, %v", b, err)
+	}
+}
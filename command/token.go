@@ -0,0 +1,202 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hashicorp/vault/api"
+	vt "github.com/mauromedda/vauth/command/token"
+	"github.com/mauromedda/vauth/pkg/login"
+	"github.com/spf13/cobra"
+)
+
+// tokenCreateRequest describes the child token created by token create.
+type tokenCreateRequest struct {
+	policies []string
+	ttl      string
+	numUses  int
+	orphan   bool
+	role     string
+	wrapTTL  string
+}
+
+// createToken creates a token with the client token as parent. With a wrap
+// TTL the returned secret holds only the wrap info.
+func createToken(client *api.Client, r tokenCreateRequest) (*api.Secret, error) {
+	if r.wrapTTL != "" {
+		client.SetWrappingLookupFunc(func(operation, path string) string {
+			return r.wrapTTL
+		})
+		defer client.SetWrappingLookupFunc(nil)
+	}
+	req := &api.TokenCreateRequest{
+		Policies: r.policies,
+		TTL:      r.ttl,
+		NumUses:  r.numUses,
+	}
+	switch {
+	case r.role != "":
+		req.NoParent = r.orphan
+		return client.Auth().Token().CreateWithRole(req, r.role)
+	case r.orphan:
+		return client.Auth().Token().CreateOrphan(req)
+	}
+	return client.Auth().Token().Create(req)
+}
+
+// tokenTableData returns the fields of a created token, or of its wrap info,
+// as printed by the table format and selected by --field.
+func tokenTableData(secret *api.Secret) map[string]interface{} {
+	if w := secret.WrapInfo; w != nil {
+		return map[string]interface{}{
+			"wrapping_token":               w.Token,
+			"wrapping_accessor":            w.Accessor,
+			"wrapping_token_ttl":           w.TTL,
+			"wrapping_token_creation_time": w.CreationTime.String(),
+			"wrapping_token_creation_path": w.CreationPath,
+		}
+	}
+	if secret.Auth == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"token":           secret.Auth.ClientToken,
+		"token_accessor":  secret.Auth.Accessor,
+		"token_duration":  secret.Auth.LeaseDuration,
+		"token_renewable": secret.Auth.Renewable,
+		"token_policies":  secret.Auth.TokenPolicies,
+		"policies":        secret.Auth.Policies,
+	}
+}
+
+// writeToken writes the created token in format: table, json or the syntax
+// of an env shell exporting it as VAULT_TOKEN, or only the value of field.
+func writeToken(out io.Writer, secret *api.Secret, address, field, format string) error {
+	data := tokenTableData(secret)
+	switch {
+	case field != "":
+		v, ok := data[field]
+		if !ok {
+			return fmt.Errorf("%s field not found", field)
+		}
+		_, err := fmt.Fprintln(out, formatValue(v))
+		return err
+	case format == "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(secret)
+	case format == "table":
+		return writeKVTable(out, data, nil)
+	}
+	if _, ok := envShells[format]; !ok {
+		return fmt.Errorf("%s format not supported", format)
+	}
+	if secret.WrapInfo != nil {
+		return fmt.Errorf("a wrapping token cannot be exported as VAULT_TOKEN, use the table or json format")
+	}
+	vars := envVars(address, secret.Auth.ClientToken)
+	if format == "github-actions" {
+		return writeGitHubEnv(out, vars)
+	}
+	return writeEnv(out, format, vars)
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCreateCmd.Flags().StringSlice("policy", nil, "Policies of the token, the parent or role ones if empty (repeatable)")
+	tokenCreateCmd.Flags().String("ttl", "", "TTL of the token (e.g. 1h), the default TTL if empty")
+	tokenCreateCmd.Flags().Int("num-uses", 0, "Number of uses of the token, unlimited when zero")
+	tokenCreateCmd.Flags().Bool("orphan", false, "Create the token without parent, it is not revoked with the stored token")
+	tokenCreateCmd.Flags().String("role", "", "Token role used to create the token")
+	tokenCreateCmd.Flags().String("wrap-ttl", "", "Wrap the token in a response-wrapping token with this TTL (e.g. 5m)")
+	tokenCreateCmd.Flags().String("field", "", "Print only the value of the given field (e.g. token)")
+	tokenCreateCmd.Flags().String("format", "table", `Output format: table, json or the syntax of the statements
+exporting VAULT_TOKEN: bash, zsh, fish, powershell, dotenv, github-actions or gitlab`)
+	tokenCreateCmd.Flags().Bool("store", false, "Replace the stored token with the created one")
+}
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage Vault tokens",
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create [--policy P]... [--ttl TTL] [--num-uses N] [--orphan] [--role R] [--wrap-ttl TTL]",
+	Short: "Create a child token of the stored token",
+	Long: `This subcommand creates a token with the stored token as parent, e.g. scoped
+tokens for the sub-jobs of a pipeline:
+
+    $ vauth token create --policy deploy --ttl 1h --num-uses 3 --field token
+    $ eval "$(vauth token create --policy deploy --ttl 1h --format bash)"
+    $ vauth token create --role ci --wrap-ttl 5m --field wrapping_token
+
+The stored token is left untouched unless --store is given. With --wrap-ttl
+only the response-wrapping token is printed, it cannot be stored.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var r tokenCreateRequest
+		var err error
+		if r.policies, err = cmd.Flags().GetStringSlice("policy"); err != nil {
+			return err
+		}
+		if r.ttl, err = cmd.Flags().GetString("ttl"); err != nil {
+			return err
+		}
+		if r.numUses, err = cmd.Flags().GetInt("num-uses"); err != nil {
+			return err
+		}
+		if r.orphan, err = cmd.Flags().GetBool("orphan"); err != nil {
+			return err
+		}
+		if r.role, err = cmd.Flags().GetString("role"); err != nil {
+			return err
+		}
+		if r.wrapTTL, err = cmd.Flags().GetString("wrap-ttl"); err != nil {
+			return err
+		}
+		field, err := cmd.Flags().GetString("field")
+		if err != nil {
+			return err
+		}
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return err
+		}
+		store, err := cmd.Flags().GetBool("store")
+		if err != nil {
+			return err
+		}
+		if _, ok := envShells[format]; !ok && format != "table" && format != "json" {
+			return fmt.Errorf("%s format not supported", format)
+		}
+		if store && r.wrapTTL != "" {
+			return fmt.Errorf("--store cannot be used with --wrap-ttl")
+		}
+		cmd.SilenceUsage = true
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := sessionClient(ctx)
+		if err != nil {
+			return err
+		}
+		secret, err := createToken(client, r)
+		if err != nil {
+			return err
+		}
+		if secret == nil || (secret.Auth == nil && secret.WrapInfo == nil) {
+			return fmt.Errorf("no token returned")
+		}
+		if store {
+			tokenHelper := &vt.InternalTokenHelper{}
+			if err := tokenHelper.Store(secret.Auth.ClientToken); err != nil {
+				return &login.Error{Kind: login.ErrPersist, Err: fmt.Errorf("error storing token: %w", err)}
+			}
+		}
+		return writeToken(os.Stdout, secret, client.Address(), field, format)
+	},
+}
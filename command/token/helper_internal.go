@@ -55,21 +55,13 @@ func (i *InternalTokenHelper) Get() (string, error) {
 	return strings.TrimSpace(buf.String()), nil
 }
 
-// Store stores the value of the token to the file
+// Store stores the value of the token to the file. The token is written to a
+// temporary file renamed over the token path, so that an interrupted Store
+// never leaves a partially written token file.
 func (i *InternalTokenHelper) Store(input string) error {
 	i.PopulateTokenPath()
-	f, err := os.OpenFile(i.tokenPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	buf := bytes.NewBufferString(input)
-	if _, err := io.Copy(f, buf); err != nil {
-		return err
-	}
-
-	return nil
+	sink := &FileSink{Path: i.tokenPath, Mode: 0600}
+	return sink.Store(input)
 }
 
 // Erase erases the value of the token
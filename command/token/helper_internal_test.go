@@ -1,9 +1,12 @@
 package token
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	vt "github.com/hashicorp/vault/command/token"
+	"github.com/mitchellh/go-homedir"
 )
 
 // TestCommand re-uses the existing Test function to ensure proper behavior of
@@ -11,3 +14,46 @@ import (
 func TestCommand(t *testing.T) {
 	vt.Test(t, &InternalTokenHelper{})
 }
+
+// TestStoreAtomic ensures Store replaces the token file without leaving
+// temporary files behind
+func TestStoreAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+	homedir.DisableCache = true
+	defer func() {
+		// Dir caches the temporary HOME even with DisableCache set
+		homedir.DisableCache = false
+		homedir.Reset()
+	}()
+
+	h := &InternalTokenHelper{}
+	for _, token := range []string{"a-long-first-token", "second"} {
+		if err := h.Store(token); err != nil {
+			t.Fatal(err)
+		}
+		got, err := h.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != token {
+			t.Fatalf("got %q, want %q", got, token)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != ".vault-token" {
+		t.Fatalf("expected only the token file, got %v", files)
+	}
+	if mode := files[0].Mode().Perm(); mode != 0600 {
+		t.Fatalf("got mode %v, want 0600", mode)
+	}
+}
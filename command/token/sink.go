@@ -0,0 +1,232 @@
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Sink is a destination of the tokens obtained by a login. Sinks compose:
+// WrappedSink and EncryptedSink transform the token before storing it in the
+// next Sink, e.g. a FileSink.
+type Sink interface {
+	Store(token string) error
+}
+
+// Ensure the InternalTokenHelper and the sinks conform to the Sink interface
+var (
+	_ Sink = (*InternalTokenHelper)(nil)
+	_ Sink = (*FileSink)(nil)
+	_ Sink = (*WrappedSink)(nil)
+	_ Sink = (*EncryptedSink)(nil)
+)
+
+// FileSink writes the token to the file Path with the permissions Mode, e.g.
+// on a volume shared with other containers.
+type FileSink struct {
+	Path string
+	Mode os.FileMode
+}
+
+// Store writes the token to a temporary file renamed over Path, so that the
+// readers never see a partially written token.
+func (s *FileSink) Store(token string) error {
+	f, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.WriteString(f, token); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(s.Mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.Path)
+}
+
+// WrappedSink stores in Sink, instead of the token, the JSON wrapping info of
+// a response-wrapping token valid for TTL, as Vault Agent does. The consumer
+// unwraps it to get the token.
+type WrappedSink struct {
+	Client *api.Client
+	TTL    time.Duration
+	Sink   Sink
+}
+
+// Store wraps the token through sys/wrapping/wrap, authenticated with the
+// token itself.
+func (s *WrappedSink) Store(token string) error {
+	client, err := s.Client.Clone()
+	if err != nil {
+		return err
+	}
+	client.SetToken(token)
+	client.SetWrappingLookupFunc(func(string, string) string {
+		return s.TTL.String()
+	})
+	secret, err := client.Logical().Write("sys/wrapping/wrap", map[string]interface{}{
+		"token": token,
+	})
+	if err != nil {
+		return fmt.Errorf("error wrapping the token: %s", err)
+	}
+	if secret == nil || secret.WrapInfo == nil {
+		return fmt.Errorf("error wrapping the token: no wrapping info in the response")
+	}
+	b, err := json.Marshal(secret.WrapInfo)
+	if err != nil {
+		return err
+	}
+	return s.Sink.Store(string(b))
+}
+
+// PublicKeyInfo is the JSON file holding the curve25519 public key of the
+// consumer of an EncryptedSink, the dh_path file of Vault Agent.
+type PublicKeyInfo struct {
+	Curve25519PublicKey []byte `json:"curve25519_public_key"`
+}
+
+// KeyPair is the JSON file holding the curve25519 key pair of the consumer of
+// an EncryptedSink.
+type KeyPair struct {
+	Curve25519PublicKey  []byte `json:"curve25519_public_key"`
+	Curve25519PrivateKey []byte `json:"curve25519_private_key"`
+}
+
+// Envelope is the JSON written by an EncryptedSink, the format of the Vault
+// Agent dh sinks.
+type Envelope struct {
+	Curve25519PublicKey []byte `json:"curve25519_public_key"`
+	Nonce               []byte `json:"nonce"`
+	EncryptedPayload    []byte `json:"encrypted_payload"`
+}
+
+// GenerateKeyPair returns a new curve25519 key pair.
+func GenerateKeyPair() (*KeyPair, error) {
+	var public, private [32]byte
+	if _, err := io.ReadFull(rand.Reader, private[:]); err != nil {
+		return nil, err
+	}
+	curve25519.ScalarBaseMult(&public, &private)
+	return &KeyPair{Curve25519PublicKey: public[:], Curve25519PrivateKey: private[:]}, nil
+}
+
+// ReadPublicKey reads the public key of a PublicKeyInfo, or KeyPair, file.
+func ReadPublicKey(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info PublicKeyInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("error decoding the public key %s: %s", path, err)
+	}
+	if len(info.Curve25519PublicKey) != 32 {
+		return nil, fmt.Errorf("invalid curve25519 public key in %s", path)
+	}
+	return info.Curve25519PublicKey, nil
+}
+
+// sharedKey returns the curve25519 shared secret of the private and public
+// keys, used as AES-256 key.
+func sharedKey(private, public []byte) ([]byte, error) {
+	if len(private) != 32 || len(public) != 32 {
+		return nil, fmt.Errorf("invalid curve25519 key size")
+	}
+	var priv, pub, shared [32]byte
+	copy(priv[:], private)
+	copy(pub[:], public)
+	curve25519.ScalarMult(&shared, &priv, &pub)
+	return shared[:], nil
+}
+
+// EncryptedSink stores in Sink the token encrypted for the consumer owning
+// the curve25519 PublicKey, as a Vault Agent dh sink with dh_type curve25519:
+// the token is encrypted with AES-GCM, AAD as additional data, using the key
+// shared by the consumer key and an ephemeral key pair.
+type EncryptedSink struct {
+	PublicKey []byte
+	AAD       string
+	Sink      Sink
+}
+
+// Store encrypts the token and stores the resulting Envelope JSON.
+func (s *EncryptedSink) Store(token string) error {
+	ephemeral, err := GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	key, err := sharedKey(ephemeral.Curve25519PrivateKey, s.PublicKey)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	b, err := json.Marshal(Envelope{
+		Curve25519PublicKey: ephemeral.Curve25519PublicKey,
+		Nonce:               nonce,
+		EncryptedPayload:    gcm.Seal(nil, nonce, []byte(token), []byte(s.AAD)),
+	})
+	if err != nil {
+		return err
+	}
+	return s.Sink.Store(string(b))
+}
+
+// Decrypt returns the token of the Envelope JSON written by an EncryptedSink
+// for the consumer owning the curve25519 private key.
+func Decrypt(envelope []byte, privateKey []byte, aad string) (string, error) {
+	var e Envelope
+	if err := json.Unmarshal(envelope, &e); err != nil {
+		return "", fmt.Errorf("error decoding the envelope: %s", err)
+	}
+	key, err := sharedKey(privateKey, e.Curve25519PublicKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(e.Nonce) != gcm.NonceSize() {
+		return "", fmt.Errorf("invalid nonce size")
+	}
+	token, err := gcm.Open(nil, e.Nonce, e.EncryptedPayload, []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("error decrypting the envelope: %s", err)
+	}
+	return string(token), nil
+}
+
+// newGCM returns the AES-GCM cipher of key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,169 @@
+package token
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+// memorySink records the stored tokens.
+type memorySink struct {
+	tokens []string
+}
+
+func (s *memorySink) Store(token string) error {
+	s.tokens = append(s.tokens, token)
+	return nil
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vauth-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &FileSink{Path: filepath.Join(dir, "token"), Mode: 0640}
+	if err := sink.Store("s.token"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(sink.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "s.token"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	fi, err := os.Stat(sink.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0640); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestEncryptedSink(t *testing.T) {
+	consumer, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &memorySink{}
+	sink := &EncryptedSink{PublicKey: consumer.Curve25519PublicKey, AAD: "app", Sink: next}
+	if err := sink.Store("s.token"); err != nil {
+		t.Fatal(err)
+	}
+	envelope := []byte(next.tokens[0])
+	if strings.Contains(string(envelope), "s.token") {
+		t.Fatal("expected the token to be encrypted")
+	}
+	var e map[string]interface{}
+	if err := json.Unmarshal(envelope, &e); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"curve25519_public_key", "nonce", "encrypted_payload"} {
+		if _, ok := e[field].(string); !ok {
+			t.Fatalf("expected the %s field in the envelope %s", field, envelope)
+		}
+	}
+
+	got, err := Decrypt(envelope, consumer.Curve25519PrivateKey, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "s.token" {
+		t.Fatalf("got %v, want %v", got, "s.token")
+	}
+
+	if _, err := Decrypt(envelope, consumer.Curve25519PrivateKey, "other"); err == nil {
+		t.Fatal("expected an error decrypting with another AAD")
+	}
+	other, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(envelope, other.Curve25519PrivateKey, "app"); err == nil {
+		t.Fatal("expected an error decrypting with another key")
+	}
+}
+
+func TestReadPublicKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vauth-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(PublicKeyInfo{Curve25519PublicKey: keys.Curve25519PublicKey})
+	path := filepath.Join(dir, "public.json")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadPublicKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(keys.Curve25519PublicKey) {
+		t.Fatalf("got %x, want %x", got, keys.Curve25519PublicKey)
+	}
+
+	ioutil.WriteFile(path, []byte(`{"curve25519_public_key": "c2hvcnQ="}`), 0644)
+	if _, err := ReadPublicKey(path); err == nil {
+		t.Fatal("expected an error with a short key")
+	}
+}
+
+func TestWrappedSink(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	client, err := vault.Client("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := vault.CreateToken("app")
+
+	consumer, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &memorySink{}
+	// Wrap then encrypt, as Vault Agent does
+	sink := &WrappedSink{
+		Client: client,
+		TTL:    5 * time.Minute,
+		Sink:   &EncryptedSink{PublicKey: consumer.Curve25519PublicKey, Sink: next},
+	}
+	if err := sink.Store(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := Decrypt([]byte(next.tokens[0]), consumer.Curve25519PrivateKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wrapInfo api.SecretWrapInfo
+	if err := json.Unmarshal([]byte(payload), &wrapInfo); err != nil {
+		t.Fatal(err)
+	}
+	if wrapInfo.Token == "" || wrapInfo.Token == token.ID || wrapInfo.TTL != 300 {
+		t.Fatalf("unexpected wrap info %+v", wrapInfo)
+	}
+
+	unwrapped, err := client.Logical().Unwrap(wrapInfo.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unwrapped.Data["token"]; got != token.ID {
+		t.Fatalf("got %v, want %v", got, token.ID)
+	}
+}
@@ -0,0 +1,163 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestCreateToken(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddTokenRole("ci", "deploy", "read")
+	parent := vault.CreateToken("deploy", "read")
+
+	createTests := []struct {
+		name         string
+		req          tokenCreateRequest
+		wantPolicies []string
+		wantTTL      time.Duration
+		wantUses     int
+		wantOrphan   bool
+		wantRole     string
+		wantErr      string
+	}{
+		{
+			name:         "child",
+			req:          tokenCreateRequest{policies: []string{"deploy"}, ttl: "30m", numUses: 3},
+			wantPolicies: []string{"deploy"},
+			wantTTL:      30 * time.Minute,
+			wantUses:     3,
+		},
+		{
+			name:         "parent policies",
+			wantPolicies: []string{"deploy", "read"},
+			wantTTL:      vaulttest.DefaultTokenTTL,
+		},
+		{
+			name:         "orphan",
+			req:          tokenCreateRequest{policies: []string{"read"}, orphan: true},
+			wantPolicies: []string{"read"},
+			wantTTL:      vaulttest.DefaultTokenTTL,
+			wantOrphan:   true,
+		},
+		{
+			name:         "role",
+			req:          tokenCreateRequest{role: "ci", ttl: "1h"},
+			wantPolicies: []string{"deploy", "read"},
+			wantTTL:      time.Hour,
+			wantRole:     "ci",
+		},
+		{name: "broader policies", req: tokenCreateRequest{policies: []string{"admin"}}, wantErr: "child policies must be subset of parent"},
+		{name: "unknown role", req: tokenCreateRequest{role: "none"}, wantErr: "unknown role none"},
+	}
+	for _, tt := range createTests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := vault.Client(parent.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret, err := createToken(client, tt.req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			child, ok := vault.LookupToken(secret.Auth.ClientToken)
+			if !ok {
+				t.Fatalf("got unknown token %s", secret.Auth.ClientToken)
+			}
+			if strings.Join(child.Policies, ",") != strings.Join(tt.wantPolicies, ",") {
+				t.Fatalf("got policies %v, want %v", child.Policies, tt.wantPolicies)
+			}
+			if child.TTL != tt.wantTTL || child.NumUses != tt.wantUses || child.Orphan != tt.wantOrphan || child.Role != tt.wantRole {
+				t.Fatalf("got ttl %s, uses %d, orphan %t, role %q, want %s, %d, %t, %q",
+					child.TTL, child.NumUses, child.Orphan, child.Role, tt.wantTTL, tt.wantUses, tt.wantOrphan, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestCreateTokenWrapped(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	client, err := vault.Client(vault.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := createToken(client, tokenCreateRequest{policies: []string{"deploy"}, wrapTTL: "5m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.WrapInfo == nil || secret.WrapInfo.TTL != 300 {
+		t.Fatalf("got %+v, want a wrap info with a 300s TTL", secret.WrapInfo)
+	}
+	unwrapped, err := client.Logical().Unwrap(secret.WrapInfo.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vault.LookupToken(unwrapped.Auth.ClientToken); !ok {
+		t.Fatalf("got unknown unwrapped token %s", unwrapped.Auth.ClientToken)
+	}
+}
+
+func TestWriteToken(t *testing.T) {
+	secret := &api.Secret{Auth: &api.SecretAuth{
+		ClientToken:   "s.child",
+		Accessor:      "acc",
+		Policies:      []string{"default", "deploy"},
+		TokenPolicies: []string{"default", "deploy"},
+		LeaseDuration: 3600,
+	}}
+	wrapped := &api.Secret{WrapInfo: &api.SecretWrapInfo{Token: "s.wrap", TTL: 300}}
+
+	writeTests := []struct {
+		name    string
+		secret  *api.Secret
+		field   string
+		format  string
+		want    []string
+		wantErr string
+	}{
+		{name: "table", secret: secret, format: "table", want: []string{"token ", "s.child", "token_accessor", `["default","deploy"]`}},
+		{name: "field", secret: secret, field: "token", format: "table", want: []string{"s.child\n"}},
+		{name: "json", secret: secret, format: "json", want: []string{`"client_token": "s.child"`}},
+		{name: "bash", secret: secret, format: "bash", want: []string{"export VAULT_ADDR='https://vault:8200'", "export VAULT_TOKEN='s.child'"}},
+		{name: "wrapped", secret: wrapped, field: "wrapping_token", format: "table", want: []string{"s.wrap\n"}},
+		{name: "wrapped export", secret: wrapped, format: "dotenv", wantErr: "cannot be exported"},
+		{name: "unknown field", secret: secret, field: "none", format: "table", wantErr: "none field not found"},
+		{name: "unknown format", secret: secret, format: "yaml", wantErr: "yaml format not supported"},
+	}
+	for _, tt := range writeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := writeToken(&out, tt.secret, "https://vault:8200", tt.field, tt.format)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Fatalf("got %q, want %q", out.String(), want)
+				}
+			}
+			if tt.format == "json" && !json.Valid(out.Bytes()) {
+				t.Fatalf("got invalid JSON %q", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mauromedda/vauth/pkg/login"
+	"github.com/spf13/cobra"
+)
+
+// Version, Commit and BuildDate describe the vauth build. They are set by
+// main from its -X linker flags.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// serverFeatures are the Vault features used by vauth with the first Vault
+// version supporting them.
+var serverFeatures = []struct {
+	name       string
+	minVersion string
+}{
+	{"response wrapping (--wrap-ttl, wrap_ttl sinks)", "0.6.0"},
+	{"kubernetes auth method", "0.8.3"},
+	{"KV v2 secrets (kv get)", "0.10.0"},
+	{"jwt auth method (gha, gitlab)", "0.10.4"},
+	{"namespaces (VAULT_NAMESPACE, Enterprise only)", "0.11.0"},
+}
+
+// vaultAPIVersion returns the version of the Vault API module vauth is built
+// with, read from the build info.
+func vaultAPIVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := "unknown"
+	for _, dep := range info.Deps {
+		v := dep.Version
+		if dep.Replace != nil {
+			v = dep.Replace.Version
+		}
+		switch dep.Path {
+		case "github.com/hashicorp/vault/api":
+			return v
+		case "github.com/hashicorp/vault":
+			// The API package is part of the Vault module up to v1.1
+			version = v
+		}
+	}
+	return version
+}
+
+// writeVersion writes the build information of vauth.
+func writeVersion(out io.Writer) error {
+	_, err := fmt.Fprintf(out, `vauth %s
+Commit:         %s
+Build date:     %s
+Go version:     %s
+Vault API:      %s
+`, Version, Commit, BuildDate, runtime.Version(), vaultAPIVersion())
+	return err
+}
+
+// parseVersion returns the major, minor and patch numbers of a Vault version,
+// e.g. 1.1.2 or v1.2.0+ent.
+func parseVersion(version string) ([3]int, error) {
+	var parts [3]int
+	v := strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(v, "+-"); i >= 0 {
+		v = v[:i]
+	}
+	fields := strings.Split(v, ".")
+	if len(fields) > 3 {
+		return parts, fmt.Errorf("invalid version %q", version)
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, fmt.Errorf("invalid version %q", version)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
+
+// olderVersion reports whether the version a precedes b.
+func olderVersion(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// checkServer writes the version of the Vault server, read from sys/health,
+// and a warning for each feature used by vauth it is too old to support.
+func checkServer(out io.Writer, client *api.Client) error {
+	health, err := client.Sys().Health()
+	if err != nil {
+		return fmt.Errorf("error reading the Vault server health: %s", err)
+	}
+	fmt.Fprintf(out, "Vault server:   %s\n", health.Version)
+	server, err := parseVersion(health.Version)
+	if err != nil {
+		fmt.Fprintf(out, "Warning: cannot check the features of the Vault server: %s\n", err)
+		return nil
+	}
+	for _, f := range serverFeatures {
+		min, err := parseVersion(f.minVersion)
+		if err != nil {
+			return err
+		}
+		if olderVersion(server, min) {
+			fmt.Fprintf(out, "Warning: Vault %s is older than %s, required by %s\n", health.Version, f.minVersion, f.name)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+	versionCmd.Flags().Bool("check-server", false, "Read the version of the Vault server and warn about the features it does not support")
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version [--check-server]",
+	Short: "Print the vauth version",
+	Long: `This subcommand prints the version, the commit and the build date of vauth,
+with the Go and Vault API versions it is built with.
+
+With --check-server it also reads the version of the Vault server at VAULT_ADDR
+from sys/health and warns when the server is older than the features used by
+vauth, e.g. response wrapping or namespaces.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		checkServerFlag, err := cmd.Flags().GetBool("check-server")
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+		if err := writeVersion(os.Stdout); err != nil {
+			return err
+		}
+		if !checkServerFlag {
+			return nil
+		}
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		client, err := login.NewClientWithLogger(ctx, nil, logger)
+		if err != nil {
+			return err
+		}
+		return checkServer(os.Stdout, client)
+	},
+}
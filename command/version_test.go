@@ -0,0 +1,90 @@
+package command
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestWriteVersion(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, BuildDate = v, c, d }(Version, Commit, BuildDate)
+	Version, Commit, BuildDate = "v1.2.3", "abc1234", "2019-05-01T00:00:00Z"
+
+	var out bytes.Buffer
+	if err := writeVersion(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"vauth v1.2.3", "Commit:         abc1234", "Build date:     2019-05-01T00:00:00Z", "Go version:     " + runtime.Version(), "Vault API:"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("got %q, want %q", out.String(), want)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	versionTests := []struct {
+		in      string
+		want    [3]int
+		wantErr bool
+	}{
+		{in: "1.1.2", want: [3]int{1, 1, 2}},
+		{in: "v0.10.4", want: [3]int{0, 10, 4}},
+		{in: "1.2.0+ent.hsm", want: [3]int{1, 2, 0}},
+		{in: "1.3.0-beta1", want: [3]int{1, 3, 0}},
+		{in: "1.4", want: [3]int{1, 4, 0}},
+		{in: "", wantErr: true},
+		{in: "one.two", wantErr: true},
+		{in: "1.2.3.4", wantErr: true},
+	}
+	for _, tt := range versionTests {
+		got, err := parseVersion(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%q: got %v, want error %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want && !tt.wantErr {
+			t.Fatalf("%q: got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckServer(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	client, err := vault.Client("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkTests := []struct {
+		version string
+		want    []string
+		notWant []string
+	}{
+		{version: "1.1.2", want: []string{"Vault server:   1.1.2"}, notWant: []string{"Warning"}},
+		{version: "0.10.1", want: []string{"older than 0.10.4, required by jwt auth method", "required by namespaces"}, notWant: []string{"KV v2", "wrapping"}},
+		{version: "0.5.3", want: []string{"required by response wrapping", "required by KV v2 secrets"}},
+		{version: "unknown", want: []string{"cannot check the features"}},
+	}
+	for _, tt := range checkTests {
+		t.Run(tt.version, func(t *testing.T) {
+			vault.SetVersion(tt.version)
+			var out bytes.Buffer
+			if err := checkServer(&out, client); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Fatalf("got %q, want %q", out.String(), want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out.String(), notWant) {
+					t.Fatalf("got %q, want no %q", out.String(), notWant)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/mauromedda/vauth
 
-go 1.12
+go 1.13
 
 replace github.com/mauromedda/vauth/command/token => ./command/token
 
@@ -14,7 +14,7 @@ require (
 	github.com/araddon/gou v0.0.0-20190110011759-c797efecbb61 // indirect
 	github.com/armon/go-proxyproto v0.0.0-20190211145416-68259f75880e // indirect
 	github.com/asaskevich/govalidator v0.0.0-20190424111038-f61b66f89f4a // indirect
-	github.com/aws/aws-sdk-go v1.19.21 // indirect
+	github.com/aws/aws-sdk-go v1.19.21
 	github.com/bitly/go-hostpool v0.0.0-20171023180738-a3a6125de932 // indirect
 	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869 // indirect
 	github.com/boombuler/barcode v1.0.0 // indirect
@@ -46,6 +46,7 @@ require (
 	github.com/grpc-ecosystem/go-grpc-prometheus v1.2.0 // indirect
 	github.com/hashicorp/consul v1.4.4 // indirect
 	github.com/hashicorp/consul/api v1.0.1 // indirect
+	github.com/hashicorp/go-hclog v0.8.0
 	github.com/hashicorp/go-memdb v1.0.1 // indirect
 	github.com/hashicorp/nomad/api v0.0.0-20190501032619-c06daf2d8a94 // indirect
 	github.com/hashicorp/vault v1.1.2
@@ -102,7 +103,7 @@ require (
 	go.uber.org/atomic v1.3.2 // indirect
 	go.uber.org/multierr v1.1.0 // indirect
 	go.uber.org/zap v1.10.0 // indirect
-	golang.org/x/crypto v0.0.0-20190426145343-a29dc8fdc734 // indirect
+	golang.org/x/crypto v0.0.0-20190426145343-a29dc8fdc734
 	golang.org/x/net v0.0.0-20190503192946-f4e77d36d62c // indirect
 	golang.org/x/sys v0.0.0-20190502175342-a43fa875dd82 // indirect
 	golang.org/x/text v0.3.2 // indirect
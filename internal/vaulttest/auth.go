@@ -0,0 +1,248 @@
+package vaulttest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// handleUserLogin serves auth/<mount>/login/<username> for the userpass-like
+// auth methods.
+func (s *Server) handleUserLogin(mount string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" && r.Method != "POST" {
+			RespondError(w, http.StatusMethodNotAllowed, "unsupported operation")
+			return
+		}
+		var body struct {
+			Password string `json:"password"`
+		}
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		username := strings.TrimPrefix(r.URL.Path, "/v1/auth/"+mount+"/login/")
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		u, ok := s.users[mount][username]
+		if !ok || u.password != body.Password {
+			RespondError(w, http.StatusBadRequest, "invalid username or password")
+			return
+		}
+		t := s.createToken("auth/"+mount+"/login/"+username, u.policies, map[string]string{"username": username})
+		respondAuth(w, t)
+	}
+}
+
+// handleAppRoleLogin serves auth/approle/login.
+func (s *Server) handleAppRoleLogin(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RoleID   string `json:"role_id"`
+		SecretID string `json:"secret_id"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role, ok := s.appRoles[body.RoleID]
+	if !ok {
+		RespondError(w, http.StatusBadRequest, "invalid role ID")
+		return
+	}
+	if role.secretID != body.SecretID {
+		RespondError(w, http.StatusBadRequest, "invalid secret id")
+		return
+	}
+	t := s.createToken("auth/approle/login", role.policies, map[string]string{"role_id": body.RoleID})
+	respondAuth(w, t)
+}
+
+// handleJWTLogin serves auth/<mount>/login for the jwt auth method.
+func (s *Server) handleJWTLogin(mount string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			JWT  string `json:"jwt"`
+			Role string `json:"role"`
+		}
+		if !decodeBody(w, r, &body) {
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		role, ok := s.jwtRoles[mount][body.Role]
+		if !ok {
+			RespondError(w, http.StatusBadRequest, fmt.Sprintf("role %q could not be found", body.Role))
+			return
+		}
+		if role.jwt != body.JWT {
+			RespondError(w, http.StatusBadRequest, "error validating token: invalid signature")
+			return
+		}
+		t := s.createToken("auth/"+mount+"/login", role.policies, map[string]string{"role": body.Role})
+		respondAuth(w, t)
+	}
+}
+
+// handleLookupSelf serves auth/token/lookup-self.
+func (s *Server) handleLookupSelf(w http.ResponseWriter, r *http.Request) {
+	t := s.Authorized(w, r)
+	if t == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	RespondData(w, tokenData(t))
+}
+
+// handleRenewSelf serves auth/token/renew-self.
+func (s *Server) handleRenewSelf(w http.ResponseWriter, r *http.Request) {
+	t := s.Authorized(w, r)
+	if t == nil {
+		return
+	}
+	var body struct {
+		Increment interface{} `json:"increment"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !t.Renewable {
+		RespondError(w, http.StatusBadRequest, "lease is not renewable")
+		return
+	}
+	t.IssueTime = time.Now()
+	if body.Increment != nil {
+		if increment, err := parseTTL(strings.Trim(jsonString(body.Increment), `"`)); err == nil && increment > 0 {
+			t.TTL = time.Duration(increment) * time.Second
+		}
+	}
+	respondAuth(w, t)
+}
+
+// handleRevokeSelf serves auth/token/revoke-self.
+func (s *Server) handleRevokeSelf(w http.ResponseWriter, r *http.Request) {
+	t := s.Authorized(w, r)
+	if t == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.tokens, t.ID)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTokenCreate serves auth/token/create, auth/token/create-orphan and
+// auth/token/create/<role>. The child policies must be a subset of the
+// parent ones, unless the parent is root.
+func (s *Server) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	parent := s.Authorized(w, r)
+	if parent == nil {
+		return
+	}
+	var body struct {
+		Policies []string    `json:"policies"`
+		TTL      interface{} `json:"ttl"`
+		NumUses  int         `json:"num_uses"`
+		NoParent bool        `json:"no_parent"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	ttl := DefaultTokenTTL
+	if body.TTL != nil {
+		seconds, err := parseTTL(strings.Trim(jsonString(body.TTL), `"`))
+		if err != nil {
+			RespondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	role := strings.TrimPrefix(path, "auth/token/create/")
+	if role != path {
+		allowed, ok := s.tokenRoles[role]
+		if !ok {
+			RespondError(w, http.StatusBadRequest, "unknown role "+role)
+			return
+		}
+		if len(body.Policies) == 0 {
+			body.Policies = allowed
+		}
+		if !subset(body.Policies, allowed) {
+			RespondError(w, http.StatusBadRequest, "token policies must be a subset of the role allowed policies")
+			return
+		}
+	} else {
+		role = ""
+		if len(body.Policies) == 0 {
+			body.Policies = parent.Policies
+		}
+		if !hasPolicy(parent.Policies, "root") && !subset(body.Policies, parent.Policies) {
+			RespondError(w, http.StatusBadRequest, "child policies must be subset of parent")
+			return
+		}
+	}
+	t := s.createToken(path, body.Policies, nil)
+	t.TTL = ttl
+	t.NumUses = body.NumUses
+	t.Role = role
+	t.Orphan = body.NoParent || strings.HasSuffix(path, "create-orphan")
+	respondAuth(w, t)
+}
+
+// subset reports whether all the policies, but default, are in allowed.
+func subset(policies, allowed []string) bool {
+	for _, p := range policies {
+		if p != "default" && !hasPolicy(allowed, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPolicy reports whether policy is in policies.
+func hasPolicy(policies []string, policy string) bool {
+	for _, p := range policies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenData returns the lookup data of t, it must be called holding s.mu.
+func tokenData(t *Token) map[string]interface{} {
+	ttl := 0
+	var expireTime interface{}
+	if t.TTL > 0 {
+		remaining := time.Until(t.IssueTime.Add(t.TTL))
+		ttl = int(remaining.Seconds())
+		expireTime = t.IssueTime.Add(t.TTL).Format(time.RFC3339Nano)
+	}
+	return map[string]interface{}{
+		"id":           t.ID,
+		"accessor":     t.Accessor,
+		"policies":     t.Policies,
+		"meta":         t.Meta,
+		"ttl":          ttl,
+		"creation_ttl": int(t.TTL.Seconds()),
+		"renewable":    t.Renewable,
+		"orphan":       t.Orphan,
+		"num_uses":     t.NumUses,
+		"path":         t.Path,
+		"issue_time":   t.IssueTime.Format(time.RFC3339Nano),
+		"expire_time":  expireTime,
+		"display_name": "token",
+		"type":         "service",
+	}
+}
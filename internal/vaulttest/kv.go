@@ -0,0 +1,228 @@
+package vaulttest
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kvVersion is a version of a KV secret.
+type kvVersion struct {
+	data    map[string]interface{}
+	created time.Time
+	deleted bool
+}
+
+// kvEngine is an in-memory KV secrets engine of version 1 or 2.
+type kvEngine struct {
+	version int
+	secrets map[string][]kvVersion
+}
+
+// EnableKV mounts at mount a KV secrets engine of the given version (1 or 2)
+// and serves sys/internal/ui/mounts for it.
+func (s *Server) EnableKV(mount string, version int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kv == nil {
+		s.kv = make(map[string]*kvEngine)
+		s.mux.HandleFunc("/v1/sys/internal/ui/mounts/", s.handleUIMounts)
+	}
+	s.kv[mount] = &kvEngine{version: version, secrets: make(map[string][]kvVersion)}
+	s.mux.HandleFunc("/v1/"+mount+"/", s.handleKV(mount))
+}
+
+// SetSecret writes a new version of the secret at path of the KV engine
+// mounted at mount.
+func (s *Server) SetSecret(mount, path string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.kv[mount]
+	e.secrets[path] = append(e.secrets[path], kvVersion{data: data, created: time.Now()})
+}
+
+// Secret returns the latest version of the secret at path of the KV engine
+// mounted at mount.
+func (s *Server) Secret(mount, path string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.kv[mount].secrets[path]
+	if len(versions) == 0 || versions[len(versions)-1].deleted {
+		return nil, false
+	}
+	return versions[len(versions)-1].data, true
+}
+
+// handleUIMounts serves sys/internal/ui/mounts/<path>, used to detect the
+// KV version.
+func (s *Server) handleUIMounts(w http.ResponseWriter, r *http.Request) {
+	if s.Authorized(w, r) == nil {
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/sys/internal/ui/mounts/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for mount, e := range s.kv {
+		if path == mount || strings.HasPrefix(path, mount+"/") {
+			RespondData(w, map[string]interface{}{
+				"path":    mount + "/",
+				"type":    "kv",
+				"options": map[string]interface{}{"version": strconv.Itoa(e.version)},
+			})
+			return
+		}
+	}
+	RespondError(w, http.StatusBadRequest, "no mount found for path "+path)
+}
+
+// handleKV serves the paths of the KV engine mounted at mount.
+func (s *Server) handleKV(mount string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Authorized(w, r) == nil {
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v1/"+mount+"/")
+		list := r.Method == "LIST" || r.URL.Query().Get("list") == "true"
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		e := s.kv[mount]
+		if e.version == 2 {
+			switch {
+			case strings.HasPrefix(path, "data/"):
+				path = strings.TrimPrefix(path, "data/")
+			case strings.HasPrefix(path, "metadata/") && list:
+				path = strings.TrimPrefix(path, "metadata/")
+			case strings.HasPrefix(path, "metadata/"):
+				e.serveMetadata(w, strings.TrimPrefix(path, "metadata/"))
+				return
+			default:
+				RespondError(w, http.StatusNotFound)
+				return
+			}
+		}
+
+		switch {
+		case list:
+			e.serveList(w, path)
+		case r.Method == "GET":
+			e.serveRead(w, r, path)
+		case r.Method == "PUT" || r.Method == "POST":
+			var body map[string]interface{}
+			if !decodeBody(w, r, &body) {
+				return
+			}
+			e.serveWrite(w, path, body)
+		case r.Method == "DELETE":
+			if versions := e.secrets[path]; len(versions) > 0 {
+				versions[len(versions)-1].deleted = true
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			RespondError(w, http.StatusMethodNotAllowed, "unsupported operation")
+		}
+	}
+}
+
+// serveRead serves the latest or the requested version of a secret.
+func (e *kvEngine) serveRead(w http.ResponseWriter, r *http.Request, path string) {
+	versions := e.secrets[path]
+	n := len(versions)
+	if v, err := strconv.Atoi(r.URL.Query().Get("version")); err == nil && v > 0 {
+		n = v
+	}
+	if n == 0 || n > len(versions) || versions[n-1].deleted {
+		RespondError(w, http.StatusNotFound)
+		return
+	}
+	v := versions[n-1]
+	if e.version == 1 {
+		RespondData(w, v.data)
+		return
+	}
+	RespondData(w, map[string]interface{}{
+		"data":     v.data,
+		"metadata": versionMetadata(v, n),
+	})
+}
+
+// serveWrite stores a new version of a secret.
+func (e *kvEngine) serveWrite(w http.ResponseWriter, path string, body map[string]interface{}) {
+	data := body
+	if e.version == 2 {
+		data, _ = body["data"].(map[string]interface{})
+		if data == nil {
+			RespondError(w, http.StatusBadRequest, "no data provided")
+			return
+		}
+	}
+	v := kvVersion{data: data, created: time.Now()}
+	e.secrets[path] = append(e.secrets[path], v)
+	if e.version == 1 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	RespondData(w, versionMetadata(v, len(e.secrets[path])))
+}
+
+// serveList lists the keys under path, the folders ending with a slash.
+func (e *kvEngine) serveList(w http.ResponseWriter, path string) {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for p, versions := range e.secrets {
+		if !strings.HasPrefix(p, prefix) || versions[len(versions)-1].deleted {
+			continue
+		}
+		key := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		RespondError(w, http.StatusNotFound)
+		return
+	}
+	sort.Strings(keys)
+	RespondData(w, map[string]interface{}{"keys": keys})
+}
+
+// serveMetadata serves the KV v2 metadata of a secret.
+func (e *kvEngine) serveMetadata(w http.ResponseWriter, path string) {
+	versions := e.secrets[path]
+	if len(versions) == 0 {
+		RespondError(w, http.StatusNotFound)
+		return
+	}
+	all := make(map[string]interface{})
+	for i, v := range versions {
+		all[strconv.Itoa(i+1)] = versionMetadata(v, i+1)
+	}
+	RespondData(w, map[string]interface{}{
+		"current_version": len(versions),
+		"versions":        all,
+	})
+}
+
+// versionMetadata returns the KV v2 metadata of the version n.
+func versionMetadata(v kvVersion, n int) map[string]interface{} {
+	deletionTime := ""
+	if v.deleted {
+		deletionTime = time.Now().Format(time.RFC3339Nano)
+	}
+	return map[string]interface{}{
+		"created_time":  v.created.Format(time.RFC3339Nano),
+		"deletion_time": deletionTime,
+		"destroyed":     false,
+		"version":       n,
+	}
+}
@@ -0,0 +1,393 @@
+// Package vaulttest provides an in-process fake Hashicorp Vault server,
+// built on net/http/httptest, implementing the subset of the Vault HTTP API
+// used by vauth. It lets the tests run offline, without Docker.
+package vaulttest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// DefaultTokenTTL is the TTL of the tokens issued by the auth endpoints.
+const DefaultTokenTTL = time.Hour
+
+// Token is a token known by the fake server.
+type Token struct {
+	ID        string
+	Accessor  string
+	Policies  []string
+	Meta      map[string]string
+	TTL       time.Duration
+	Renewable bool
+	Orphan    bool
+	NumUses   int
+	Role      string
+	Path      string
+	IssueTime time.Time
+}
+
+type user struct {
+	password string
+	policies []string
+}
+
+type appRole struct {
+	secretID string
+	policies []string
+}
+
+type jwtRole struct {
+	jwt      string
+	policies []string
+}
+
+type wrappedResponse struct {
+	body         []byte
+	creationPath string
+	creationTime time.Time
+	ttl          int
+}
+
+// Server is a fake Vault server. Create it with NewServer and release it
+// with Close.
+type Server struct {
+	// URL is the base URL of the server, to be used as VAULT_ADDR.
+	URL string
+
+	// RootToken is a never expiring token with the root policy.
+	RootToken string
+
+	server *httptest.Server
+	mux    *http.ServeMux
+
+	mu         sync.Mutex
+	sealed     bool
+	standby    bool
+	version    string
+	tokens     map[string]*Token
+	users      map[string]map[string]user
+	appRoles   map[string]appRole
+	jwtRoles   map[string]map[string]jwtRole
+	tokenRoles map[string][]string
+	policies   map[string]map[string][]string
+	wrapped    map[string]wrappedResponse
+	kv         map[string]*kvEngine
+}
+
+// NewServer starts and returns a new fake Vault server, unsealed and active.
+func NewServer() *Server {
+	s := &Server{
+		mux:        http.NewServeMux(),
+		version:    "1.1.2",
+		tokens:     make(map[string]*Token),
+		users:      make(map[string]map[string]user),
+		appRoles:   make(map[string]appRole),
+		jwtRoles:   make(map[string]map[string]jwtRole),
+		tokenRoles: make(map[string][]string),
+		policies:   make(map[string]map[string][]string),
+		wrapped:    make(map[string]wrappedResponse),
+	}
+	root := s.CreateToken("root")
+	root.TTL = 0
+	root.Renewable = false
+	s.RootToken = root.ID
+
+	s.mux.HandleFunc("/v1/sys/health", s.handleHealth)
+	s.mux.HandleFunc("/v1/auth/token/lookup-self", s.handleLookupSelf)
+	s.mux.HandleFunc("/v1/auth/token/renew-self", s.handleRenewSelf)
+	s.mux.HandleFunc("/v1/auth/token/revoke-self", s.handleRevokeSelf)
+	s.mux.HandleFunc("/v1/auth/token/create", s.handleTokenCreate)
+	s.mux.HandleFunc("/v1/auth/token/create-orphan", s.handleTokenCreate)
+	s.mux.HandleFunc("/v1/auth/token/create/", s.handleTokenCreate)
+	s.mux.HandleFunc("/v1/auth/approle/login", s.handleAppRoleLogin)
+	s.mux.HandleFunc("/v1/sys/wrapping/wrap", s.handleWrap)
+	s.mux.HandleFunc("/v1/sys/wrapping/unwrap", s.handleUnwrap)
+	s.mux.HandleFunc("/v1/sys/wrapping/lookup", s.handleWrapLookup)
+	s.mux.HandleFunc("/v1/sys/capabilities-self", s.handleCapabilitiesSelf)
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns a Vault API client talking to the server with token.
+func (s *Server) Client(token string) (*api.Client, error) {
+	config := api.DefaultConfig()
+	config.Address = s.URL
+	config.MaxRetries = 0
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+	return client, nil
+}
+
+// HandleFunc registers an additional endpoint, e.g. a secret engine path,
+// with the same semantic of http.ServeMux. Use Authorized to check the
+// request token.
+func (s *Server) HandleFunc(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// SetSealed seals or unseals the server. A sealed server answers 503 to
+// every request but sys/health.
+func (s *Server) SetSealed(sealed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sealed = sealed
+}
+
+// SetStandby turns the server into a standby node, as reported by sys/health.
+func (s *Server) SetStandby(standby bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.standby = standby
+}
+
+// SetVersion sets the Vault version reported by sys/health.
+func (s *Server) SetVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+// AddUser creates a user in the userpass-like auth method enabled at mount
+// (e.g. userpass, ldap or radius).
+func (s *Server) AddUser(mount, username, password string, policies ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[mount]; !ok {
+		s.users[mount] = make(map[string]user)
+		s.mux.HandleFunc("/v1/auth/"+mount+"/login/", s.handleUserLogin(mount))
+	}
+	s.users[mount][username] = user{password: password, policies: policies}
+}
+
+// AddAppRole creates an AppRole with the given role_id and secret_id.
+func (s *Server) AddAppRole(roleID, secretID string, policies ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appRoles[roleID] = appRole{secretID: secretID, policies: policies}
+}
+
+// AddJWTRole creates a role in the jwt auth method enabled at mount,
+// accepting only the given jwt.
+func (s *Server) AddJWTRole(mount, role, jwt string, policies ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jwtRoles[mount]; !ok {
+		s.jwtRoles[mount] = make(map[string]jwtRole)
+		s.mux.HandleFunc("/v1/auth/"+mount+"/login", s.handleJWTLogin(mount))
+	}
+	s.jwtRoles[mount][role] = jwtRole{jwt: jwt, policies: policies}
+}
+
+// AddTokenRole creates a token role issuing tokens with the allowed
+// policies, all of them by default.
+func (s *Server) AddTokenRole(name string, allowedPolicies ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenRoles[name] = allowedPolicies
+}
+
+// SetCapabilities grants the capabilities on path to the policy. A path
+// ending with * matches the paths with that prefix.
+func (s *Server) SetCapabilities(policy, path string, capabilities ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policies[policy] == nil {
+		s.policies[policy] = make(map[string][]string)
+	}
+	s.policies[policy][path] = capabilities
+}
+
+// CreateToken issues a new renewable token with the given policies and the
+// DefaultTokenTTL.
+func (s *Server) CreateToken(policies ...string) *Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createToken("auth/token/create", policies, nil)
+}
+
+// LookupToken returns the token with the given id, if it exists.
+func (s *Server) LookupToken(id string) (*Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	return t, ok
+}
+
+// Authorized returns the token of the request if it is valid, otherwise it
+// writes a permission denied error and returns nil.
+func (s *Server) Authorized(w http.ResponseWriter, r *http.Request) *Token {
+	s.mu.Lock()
+	t, ok := s.tokens[r.Header.Get("X-Vault-Token")]
+	s.mu.Unlock()
+	if !ok {
+		RespondError(w, http.StatusForbidden, "permission denied")
+		return nil
+	}
+	return t
+}
+
+// createToken must be called holding s.mu.
+func (s *Server) createToken(path string, policies []string, meta map[string]string) *Token {
+	t := &Token{
+		ID:        "s." + randomID(12),
+		Accessor:  randomID(12),
+		Policies:  append([]string{}, policies...),
+		Meta:      meta,
+		TTL:       DefaultTokenTTL,
+		Renewable: true,
+		Orphan:    true,
+		Path:      path,
+		IssueTime: time.Now(),
+	}
+	s.tokens[t.ID] = t
+	return t
+}
+
+// serveHTTP checks the seal status and handles the response wrapping before
+// dispatching the request to the endpoint.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	sealed := s.sealed
+	s.mu.Unlock()
+	if sealed && r.URL.Path != "/v1/sys/health" {
+		RespondError(w, http.StatusServiceUnavailable, "Vault is sealed")
+		return
+	}
+
+	wrapTTL := r.Header.Get("X-Vault-Wrap-TTL")
+	if wrapTTL == "" || strings.HasPrefix(r.URL.Path, "/v1/sys/wrapping/") {
+		s.mux.ServeHTTP(w, r)
+		return
+	}
+	ttl, err := parseTTL(wrapTTL)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+		return
+	}
+	s.respondWrapped(w, strings.TrimPrefix(r.URL.Path, "/v1/"), rec.Body.Bytes(), ttl)
+}
+
+// respondWrapped stores body and answers with the wrap_info of a new
+// wrapping token.
+func (s *Server) respondWrapped(w http.ResponseWriter, path string, body []byte, ttl int) {
+	s.mu.Lock()
+	t := s.createToken("sys/wrapping/wrap", []string{"response-wrapping"}, nil)
+	t.TTL = time.Duration(ttl) * time.Second
+	t.Renewable = false
+	now := time.Now()
+	s.wrapped[t.ID] = wrappedResponse{body: body, creationPath: path, creationTime: now, ttl: ttl}
+	s.mu.Unlock()
+
+	RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"wrap_info": map[string]interface{}{
+			"token":            t.ID,
+			"accessor":         t.Accessor,
+			"ttl":              ttl,
+			"creation_time":    now.Format(time.RFC3339Nano),
+			"creation_path":    path,
+			"wrapped_accessor": "",
+		},
+	})
+}
+
+// RespondJSON writes v encoded as JSON with the given status code.
+func RespondJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// RespondError writes a Vault error response.
+func RespondError(w http.ResponseWriter, status int, errors ...string) {
+	RespondJSON(w, status, map[string][]string{"errors": errors})
+}
+
+// RespondData writes a Vault secret response holding data.
+func RespondData(w http.ResponseWriter, data map[string]interface{}) {
+	RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"request_id": randomID(8),
+		"data":       data,
+	})
+}
+
+// respondAuth writes the auth response of a successful login issuing t.
+func respondAuth(w http.ResponseWriter, t *Token) {
+	RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"request_id": randomID(8),
+		"auth": map[string]interface{}{
+			"client_token":   t.ID,
+			"accessor":       t.Accessor,
+			"policies":       t.Policies,
+			"token_policies": t.Policies,
+			"metadata":       t.Meta,
+			"lease_duration": int(t.TTL.Seconds()),
+			"renewable":      t.Renewable,
+			"orphan":         t.Orphan,
+		},
+	})
+}
+
+// decodeBody decodes the JSON request body into v, writing a 400 error and
+// returning false on failure.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil {
+		return true
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		RespondError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse JSON input: %s", err))
+		return false
+	}
+	return true
+}
+
+// parseTTL parses a TTL expressed in seconds or as a Go duration.
+func parseTTL(s string) (int, error) {
+	var seconds int
+	if _, err := fmt.Sscanf(s, "%d", &seconds); err == nil && fmt.Sprint(seconds) == s {
+		return seconds, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return int(d.Seconds()), nil
+}
+
+// randomID returns a random hex string of n bytes.
+func randomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,194 @@
+package vaulttest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServerUserpass(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddUser("userpass", "test", "test", "default")
+
+	client, err := s.Client("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := client.Logical().Write("auth/userpass/login/test", map[string]interface{}{"password": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := secret.TokenID()
+	if err != nil || token == "" {
+		t.Fatalf("expected a token, got %q %v", token, err)
+	}
+
+	_, err = client.Logical().Write("auth/userpass/login/test", map[string]interface{}{"password": "wrong"})
+	if err == nil || !strings.Contains(err.Error(), "invalid username or password") {
+		t.Fatalf("expected invalid username or password, got %v", err)
+	}
+}
+
+func TestServerTokenLifecycle(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddAppRole("role", "secret", "app")
+
+	client, err := s.Client("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := client.Logical().Write("auth/approle/login", map[string]interface{}{
+		"role_id":   "role",
+		"secret_id": "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(secret.Auth.ClientToken)
+
+	lookup, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := lookup.TokenID(); id != secret.Auth.ClientToken {
+		t.Fatalf("got %q, want %q", id, secret.Auth.ClientToken)
+	}
+
+	renewed, err := client.Auth().Token().RenewSelf(7200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Auth.LeaseDuration != 7200 {
+		t.Fatalf("got lease duration %d, want 7200", renewed.Auth.LeaseDuration)
+	}
+
+	if err := client.Auth().Token().RevokeSelf(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Auth().Token().LookupSelf(); err == nil {
+		t.Fatal("expected an error looking up a revoked token")
+	}
+}
+
+func TestServerWrapping(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddUser("userpass", "test", "test")
+
+	client, err := s.Client("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetWrappingLookupFunc(func(string, string) string { return "5m" })
+	wrapped, err := client.Logical().Write("auth/userpass/login/test", map[string]interface{}{"password": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped.WrapInfo == nil || wrapped.WrapInfo.TTL != 300 {
+		t.Fatalf("expected a wrapped response, got %#v", wrapped)
+	}
+
+	client.SetWrappingLookupFunc(nil)
+	client.SetToken(s.RootToken)
+	unwrapped, err := client.Logical().Unwrap(wrapped.WrapInfo.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped.Auth == nil || unwrapped.Auth.ClientToken == "" {
+		t.Fatalf("expected the login response, got %#v", unwrapped)
+	}
+	if _, err := client.Logical().Unwrap(wrapped.WrapInfo.Token); err == nil {
+		t.Fatal("expected an error unwrapping twice")
+	}
+}
+
+func TestServerSealed(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetSealed(true)
+
+	client, err := s.Client(s.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	health, err := client.Sys().Health()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Sealed {
+		t.Fatal("expected a sealed server")
+	}
+	resp, err := http.Get(s.URL + "/v1/sys/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want 503", resp.StatusCode)
+	}
+	if _, err := client.Auth().Token().LookupSelf(); err == nil || !strings.Contains(err.Error(), "sealed") {
+		t.Fatalf("expected a sealed error, got %v", err)
+	}
+}
+
+func TestServerKV(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.EnableKV("kv", 1)
+	s.EnableKV("secret", 2)
+
+	client, err := s.Client(s.RootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Logical().Write("kv/app/db", map[string]interface{}{"password": "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"first", "second"} {
+		data := map[string]interface{}{"data": map[string]interface{}{"password": password}}
+		if _, err := client.Logical().Write("secret/data/app/db", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secret, err := client.Logical().Read("kv/app/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["password"] != "v1" {
+		t.Errorf("got %v, want v1", secret.Data)
+	}
+
+	secret, err = client.Logical().ReadWithData("secret/data/app/db", map[string][]string{"version": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := secret.Data["data"].(map[string]interface{}); data["password"] != "first" {
+		t.Errorf("got %v, want first", data)
+	}
+
+	list, err := client.Logical().List("secret/metadata/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := list.Data["keys"].([]interface{}); len(keys) != 1 || keys[0] != "db" {
+		t.Errorf("got keys %v, want [db]", keys)
+	}
+
+	mount, err := client.Logical().Read("sys/internal/ui/mounts/secret/app/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := mount.Data["options"].(map[string]interface{}); options["version"] != "2" {
+		t.Errorf("got options %v, want version 2", options)
+	}
+
+	if _, err := client.Logical().Delete("secret/data/app/db"); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := client.Logical().Read("secret/data/app/db"); err != nil || secret != nil {
+		t.Errorf("expected a deleted secret, got %v %v", secret, err)
+	}
+}
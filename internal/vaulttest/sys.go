@@ -0,0 +1,189 @@
+package vaulttest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleHealth serves sys/health, honouring the *code query parameters.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	sealed, standby, version := s.sealed, s.standby, s.version
+	s.mu.Unlock()
+
+	status := http.StatusOK
+	switch {
+	case sealed:
+		status = statusParam(r, "sealedcode", http.StatusServiceUnavailable)
+	case standby:
+		status = statusParam(r, "standbycode", http.StatusTooManyRequests)
+	}
+	RespondJSON(w, status, map[string]interface{}{
+		"initialized":     true,
+		"sealed":          sealed,
+		"standby":         standby,
+		"server_time_utc": time.Now().Unix(),
+		"version":         version,
+		"cluster_name":    "vaulttest",
+	})
+}
+
+// handleCapabilitiesSelf serves sys/capabilities-self, with the
+// capabilities granted by SetCapabilities to the policies of the token.
+func (s *Server) handleCapabilitiesSelf(w http.ResponseWriter, r *http.Request) {
+	t := s.Authorized(w, r)
+	if t == nil {
+		return
+	}
+	var body struct {
+		Path  string   `json:"path"`
+		Paths []string `json:"paths"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	paths := body.Paths
+	if body.Path != "" {
+		paths = append(paths, body.Path)
+	}
+	if len(paths) == 0 {
+		RespondError(w, http.StatusBadRequest, "missing paths")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := make(map[string]interface{})
+	var capabilities []string
+	for _, path := range paths {
+		capabilities = s.capabilities(t, strings.TrimPrefix(path, "/"))
+		data[path] = capabilities
+	}
+	// As Vault, the capabilities of the last path are also returned alone
+	data["capabilities"] = capabilities
+	RespondData(w, data)
+}
+
+// capabilities returns the sorted capabilities of t on path, it must be
+// called holding s.mu.
+func (s *Server) capabilities(t *Token, path string) []string {
+	set := make(map[string]bool)
+	for _, policy := range t.Policies {
+		if policy == "root" {
+			return []string{"root"}
+		}
+		for p, caps := range s.policies[policy] {
+			if p == path || (strings.HasSuffix(p, "*") && strings.HasPrefix(path, strings.TrimSuffix(p, "*"))) {
+				for _, c := range caps {
+					set[c] = true
+				}
+			}
+		}
+	}
+	if len(set) == 0 || set["deny"] {
+		return []string{"deny"}
+	}
+	capabilities := make([]string, 0, len(set))
+	for c := range set {
+		capabilities = append(capabilities, c)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// handleWrap serves sys/wrapping/wrap, wrapping the request data.
+func (s *Server) handleWrap(w http.ResponseWriter, r *http.Request) {
+	if s.Authorized(w, r) == nil {
+		return
+	}
+	ttl, err := parseTTL(r.Header.Get("X-Vault-Wrap-TTL"))
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, "wrapping TTL is required")
+		return
+	}
+	var data map[string]interface{}
+	if !decodeBody(w, r, &data) {
+		return
+	}
+	body, _ := json.Marshal(map[string]interface{}{"data": data})
+	s.respondWrapped(w, "sys/wrapping/wrap", body, ttl)
+}
+
+// handleUnwrap serves sys/wrapping/unwrap, returning the wrapped response
+// only once.
+func (s *Server) handleUnwrap(w http.ResponseWriter, r *http.Request) {
+	id := wrappingToken(w, r)
+	if id == "" {
+		return
+	}
+	s.mu.Lock()
+	wrapped, ok := s.wrapped[id]
+	if ok {
+		delete(s.wrapped, id)
+		delete(s.tokens, id)
+	}
+	s.mu.Unlock()
+	if !ok {
+		RespondError(w, http.StatusBadRequest, "wrapping token is not valid or does not exist")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(wrapped.body)
+}
+
+// handleWrapLookup serves sys/wrapping/lookup.
+func (s *Server) handleWrapLookup(w http.ResponseWriter, r *http.Request) {
+	id := wrappingToken(w, r)
+	if id == "" {
+		return
+	}
+	s.mu.Lock()
+	wrapped, ok := s.wrapped[id]
+	s.mu.Unlock()
+	if !ok {
+		RespondError(w, http.StatusBadRequest, "wrapping token is not valid or does not exist")
+		return
+	}
+	RespondData(w, map[string]interface{}{
+		"creation_path": wrapped.creationPath,
+		"creation_time": wrapped.creationTime.Format(time.RFC3339Nano),
+		"creation_ttl":  wrapped.ttl,
+	})
+}
+
+// wrappingToken returns the wrapping token from the request body or, if
+// missing, from the X-Vault-Token header.
+func wrappingToken(w http.ResponseWriter, r *http.Request) string {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if !decodeBody(w, r, &body) {
+		return ""
+	}
+	if body.Token == "" {
+		body.Token = r.Header.Get("X-Vault-Token")
+	}
+	if body.Token == "" {
+		RespondError(w, http.StatusBadRequest, "missing wrapping token")
+	}
+	return body.Token
+}
+
+// statusParam returns the status code requested with the query parameter
+// name or def.
+func statusParam(r *http.Request, name string, def int) int {
+	if code, err := strconv.Atoi(r.URL.Query().Get(name)); err == nil {
+		return code
+	}
+	return def
+}
+
+// jsonString returns the JSON encoding of v as a string.
+func jsonString(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
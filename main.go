@@ -2,6 +2,14 @@ package main
 
 import "github.com/mauromedda/vauth/command"
 
+// Set by the -X linker flags of the Makefile.
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
 func main() {
+	command.Version, command.Commit, command.BuildDate = version, commit, date
 	command.Execute()
 }
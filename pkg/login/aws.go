@@ -0,0 +1,307 @@
+package login
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// DefaultAWSMetadataURL is the address of the EC2 instance metadata
+	// service.
+	DefaultAWSMetadataURL = "http://169.254.169.254"
+	// awsServerIDHeader is the header signed with the value of header_value,
+	// checked by the aws auth method against its iam_server_id_header_value.
+	awsServerIDHeader = "X-Vault-AWS-IAM-Server-ID"
+)
+
+// AWSHandler logs in through an aws auth method, with the iam type by
+// signing an STS GetCallerIdentity request with the credentials resolved by
+// the AWS SDK, with the ec2 type with the PKCS7 signed identity document of
+// the instance.
+type AWSHandler struct{}
+
+// Auth implements Handler.
+func (h *AWSHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, error) {
+	return h.AuthContext(context.Background(), c, m)
+}
+
+// AuthContext implements ContextHandler.
+func (h *AWSHandler) AuthContext(ctx context.Context, c *api.Client, m map[string]string) (*api.Secret, error) {
+	return h.AuthExplain(ctx, c, m, nil)
+}
+
+// AuthExplain implements ExplainHandler: it writes to w the credential
+// source and the signed STS endpoint, or the identity document source.
+func (h *AWSHandler) AuthExplain(ctx context.Context, c *api.Client, m map[string]string, w io.Writer) (*api.Secret, error) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	var data map[string]interface{}
+	var err error
+	switch m["type"] {
+	case "", "iam":
+		data, err = awsIAMLoginData(m, w)
+	case "ec2":
+		data, err = awsEC2LoginData(ctx, m, w)
+	default:
+		return nil, fmt.Errorf("unsupported type %q, expected iam or ec2", m["type"])
+	}
+	if err != nil {
+		return nil, err
+	}
+	data["role"] = m["role"]
+
+	mount := m["mount"]
+	if mount == "" {
+		mount = "aws"
+	}
+	return writeLogin(c, mount, data)
+}
+
+// awsIAMLoginData returns the login data of the iam type: an STS
+// GetCallerIdentity request signed with the credentials of the static keys,
+// or of the AWS SDK chain for the profile, optionally used to assume
+// role_arn first.
+func awsIAMLoginData(m map[string]string, w io.Writer) (map[string]interface{}, error) {
+	config := aws.Config{HTTPClient: externalClient}
+	if m["aws_access_key_id"] != "" || m["aws_secret_access_key"] != "" {
+		config.Credentials = credentials.NewStaticCredentials(m["aws_access_key_id"], m["aws_secret_access_key"], m["aws_security_token"])
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            config,
+		Profile:           m["profile"],
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading the AWS configuration: %s", err)
+	}
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the AWS credentials: %s", err)
+	}
+	if m["profile"] != "" {
+		fmt.Fprintf(w, "AWS profile:        %s\n", m["profile"])
+	}
+	fmt.Fprintf(w, "Credential source:  %s\n", creds.ProviderName)
+
+	// Sign the global endpoint, the default of the aws auth method, unless
+	// a region or an endpoint is configured
+	region := m["region"]
+	endpoint := m["sts_endpoint"]
+	if endpoint == "" && region != "" {
+		endpoint = awsSTSEndpoint(region)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	stsConfig := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		stsConfig = stsConfig.WithEndpoint(endpoint)
+	}
+
+	stsCreds := sess.Config.Credentials
+	if roleARN := m["role_arn"]; roleARN != "" {
+		sessionName := m["role_session_name"]
+		if sessionName == "" {
+			sessionName = "vauth"
+		}
+		stsCreds = credentials.NewCredentials(&stscreds.AssumeRoleProvider{
+			Client:          sts.New(sess, stsConfig),
+			RoleARN:         roleARN,
+			RoleSessionName: sessionName,
+			Duration:        stscreds.DefaultDuration,
+		})
+		if _, err := stsCreds.Get(); err != nil {
+			return nil, fmt.Errorf("error assuming the role %s: %s", roleARN, err)
+		}
+		fmt.Fprintf(w, "Assumed role:       %s (session %s)\n", roleARN, sessionName)
+	}
+
+	svc := sts.New(sess, stsConfig.Copy().WithCredentials(stsCreds))
+	req, _ := svc.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	if v := m["header_value"]; v != "" {
+		req.HTTPRequest.Header.Add(awsServerIDHeader, v)
+	}
+	if err := req.Sign(); err != nil {
+		return nil, fmt.Errorf("error signing the STS request: %s", err)
+	}
+	fmt.Fprintf(w, "STS endpoint:       %s\n", req.HTTPRequest.URL)
+	fmt.Fprintf(w, "Signing region:     %s\n", req.ClientInfo.SigningRegion)
+	if v := m["header_value"]; v != "" {
+		fmt.Fprintf(w, "Server ID header:   %s\n", v)
+	}
+
+	headers, err := json.Marshal(req.HTTPRequest.Header)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(req.HTTPRequest.Body)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"iam_http_request_method": req.HTTPRequest.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(req.HTTPRequest.URL.String())),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(body),
+	}, nil
+}
+
+// awsSTSEndpoint returns the regional STS endpoint of region.
+func awsSTSEndpoint(region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return fmt.Sprintf("https://sts.%s.amazonaws.com.cn", region)
+	}
+	return fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+}
+
+// awsEC2LoginData returns the login data of the ec2 type: the PKCS7 signed
+// identity document read from the instance metadata service, with IMDSv2
+// when available.
+func awsEC2LoginData(ctx context.Context, m map[string]string, w io.Writer) (map[string]interface{}, error) {
+	metadataURL := strings.TrimSuffix(m["metadata_url"], "/")
+	if metadataURL == "" {
+		metadataURL = DefaultAWSMetadataURL
+	}
+
+	// Fall back to IMDSv1 when no session token can be requested
+	var token string
+	req, err := http.NewRequest("PUT", metadataURL+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	if b, err := doExternal(ctx, req); err == nil {
+		token = strings.TrimSpace(string(b))
+	}
+
+	req, err = http.NewRequest("GET", metadataURL+"/latest/dynamic/instance-identity/pkcs7", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	b, err := doExternal(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the instance identity document: %s", err)
+	}
+	pkcs7 := strings.Replace(strings.TrimSpace(string(b)), "\n", "", -1)
+	if pkcs7 == "" {
+		return nil, fmt.Errorf("empty instance identity document")
+	}
+	imds := "IMDSv1"
+	if token != "" {
+		imds = "IMDSv2"
+	}
+	fmt.Fprintf(w, "Identity document:  PKCS7 from %s (%s)\n", metadataURL, imds)
+
+	data := map[string]interface{}{"pkcs7": pkcs7}
+	if nonce := m["nonce"]; nonce != "" {
+		data["nonce"] = nonce
+	}
+	return data, nil
+}
+
+// Help implements Handler.
+func (h *AWSHandler) Help() string {
+	help := `
+Usage: vauth login -m aws [CONFIG K=V...]
+
+  The aws method logs in through an aws auth method. With the iam type an
+  STS GetCallerIdentity request is signed with the AWS IAM credentials,
+  resolved in order of precedence from:
+
+    1. The aws_access_key_id and aws_secret_access_key parameters
+
+    2. The standard AWS environment variables (AWS_ACCESS_KEY_ID, etc.)
+
+    3. The ~/.aws/credentials and ~/.aws/config files, for the profile
+       (--aws-profile or AWS_PROFILE)
+
+    4. The ECS task role or the EC2 instance profile
+
+  With the ec2 type the PKCS7 signed identity document of the EC2 instance
+  is sent instead. Use --explain to print the credential source and the
+  signed STS endpoint.
+
+  Authenticate using locally stored credentials:
+
+      $ vauth login -m aws role=app
+
+  Authenticate assuming a role, signing the regional STS endpoint:
+
+      $ vauth login -m aws --aws-profile dev --aws-region eu-west-1 \
+          role=app role_arn=arn:aws:iam::123456789012:role/vault-login
+
+  Authenticate an EC2 instance with its identity document:
+
+      $ vauth login -m aws type=ec2 role=app nonce=@/var/lib/vauth/nonce
+
+Configuration:
+
+  aws_access_key_id=<string>
+      Explicit AWS access key ID
+
+  aws_secret_access_key=<string>
+      Explicit AWS secret access key
+
+  aws_security_token=<string>
+      Explicit AWS security token for temporary credentials
+
+  header_value=<string>
+      Value for the X-Vault-AWS-IAM-Server-ID header in requests
+
+  metadata_url=<string>
+      Address of the EC2 instance metadata service of the ec2 type.
+      Defaults to http://169.254.169.254.
+
+  mount=<string>
+      Path where the AWS credential method is mounted. This is usually provided
+      via the -path flag in the "vauth login" command, but it can be specified
+      here as well. If specified here, it takes precedence over the value for
+      -path. The default value is "aws".
+
+  nonce=<string>
+      Client nonce of the ec2 type, required to log in again from the same
+      instance.
+
+  profile=<string>
+      AWS profile of the shared configuration files, also set by
+      --aws-profile.
+
+  region=<string>
+      Region of the STS endpoint signed, also set by --aws-region. Defaults
+      to the global endpoint, signed for us-east-1.
+
+  role=<string>
+      Name of the role to request a token against
+
+  role_arn=<string>
+      ARN of an AWS role to assume before signing the request.
+
+  role_session_name=<string>
+      Session name of the assumed role. Defaults to "vauth".
+
+  sts_endpoint=<string>
+      STS endpoint to assume the role and to sign, overriding region.
+
+  type=<string>
+      Login type, iam or ec2. Defaults to iam.
+`
+
+	return strings.TrimSpace(help)
+}
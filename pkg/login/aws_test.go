@@ -0,0 +1,254 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+// newSTS returns a stand-in for STS answering AssumeRole with the ASIAROLE
+// access key.
+func newSTS() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("Action") != "AssumeRole" {
+			http.Error(w, "unsupported action", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+      <SecretAccessKey>role-secret</SecretAccessKey>
+      <SessionToken>role-session</SessionToken>
+      <Expiration>2100-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/login/` + r.FormValue("RoleSessionName") + `</Arn>
+      <AssumedRoleId>AROA:vauth</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`))
+	}))
+}
+
+// newEC2Metadata returns a stand-in for the EC2 instance metadata service,
+// requiring an IMDSv2 token when v2 is set.
+func newEC2Metadata(v2 bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if !v2 || r.Method != "PUT" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("imds-token"))
+	})
+	mux.HandleFunc("/latest/dynamic/instance-identity/pkcs7", func(w http.ResponseWriter, r *http.Request) {
+		if v2 && r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("MIAG\nCSqG\n"))
+	})
+	return httptest.NewServer(mux)
+}
+
+// decodeIAMRequest returns the URL and the headers of the signed request of
+// an iam login.
+func decodeIAMRequest(t *testing.T, login map[string]interface{}) (string, http.Header) {
+	rawURL, err := base64.StdEncoding.DecodeString(login["iam_request_url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawHeaders, err := base64.StdEncoding.DecodeString(login["iam_request_headers"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var headers http.Header
+	if err := json.Unmarshal(rawHeaders, &headers); err != nil {
+		t.Fatal(err)
+	}
+	return string(rawURL), headers
+}
+
+func TestAWSLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vauth-aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sharedCredentials := filepath.Join(dir, "credentials")
+	if err := ioutil.WriteFile(sharedCredentials, []byte("[dev]\naws_access_key_id = AKIADEV\naws_secret_access_key = dev-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer setenv(map[string]string{
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+		"AWS_SESSION_TOKEN":           "",
+		"AWS_PROFILE":                 "",
+		"AWS_REGION":                  "",
+		"AWS_CONFIG_FILE":             filepath.Join(dir, "config"),
+		"AWS_SHARED_CREDENTIALS_FILE": sharedCredentials,
+		"AWS_EC2_METADATA_DISABLED":   "true",
+	})()
+
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	var login map[string]interface{}
+	vault.HandleFunc("/v1/auth/aws/login", func(w http.ResponseWriter, r *http.Request) {
+		login = nil
+		json.NewDecoder(r.Body).Decode(&login)
+		token := vault.CreateToken("app")
+		vaulttest.RespondJSON(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token.ID, "lease_duration": 3600},
+		})
+	})
+	sts := newSTS()
+	defer sts.Close()
+	imds := newEC2Metadata(true)
+	defer imds.Close()
+	imdsV1 := newEC2Metadata(false)
+	defer imdsV1.Close()
+
+	static := map[string]string{"role": "app", "aws_access_key_id": "AKIASTATIC", "aws_secret_access_key": "static-secret"}
+	with := func(params map[string]string, extra ...string) map[string]string {
+		m := make(map[string]string)
+		for k, v := range params {
+			m[k] = v
+		}
+		for i := 0; i < len(extra); i += 2 {
+			m[extra[i]] = extra[i+1]
+		}
+		return m
+	}
+
+	awsTests := []struct {
+		name       string
+		params     map[string]string
+		wantURL    string
+		wantCred   string
+		wantHeader string
+		wantPKCS7  string
+		wantNonce  string
+		explain    []string
+		wantErr    string
+	}{
+		{
+			name:     "static keys",
+			params:   static,
+			wantURL:  "https://sts.amazonaws.com",
+			wantCred: "Credential=AKIASTATIC/",
+			explain:  []string{"Credential source:  StaticProvider", "STS endpoint:       https://sts.amazonaws.com", "Signing region:     us-east-1"},
+		},
+		{
+			name:       "region and server ID",
+			params:     with(static, "region", "eu-west-1", "header_value", "vault.example.com"),
+			wantURL:    "https://sts.eu-west-1.amazonaws.com",
+			wantCred:   "/eu-west-1/sts/aws4_request",
+			wantHeader: "vault.example.com",
+			explain:    []string{"Signing region:     eu-west-1", "Server ID header:   vault.example.com"},
+		},
+		{
+			name:     "profile",
+			params:   map[string]string{"role": "app", "profile": "dev"},
+			wantURL:  "https://sts.amazonaws.com",
+			wantCred: "Credential=AKIADEV/",
+			explain:  []string{"AWS profile:        dev", "Credential source:  SharedConfigCredentials: " + sharedCredentials},
+		},
+		{
+			name:     "assume role",
+			params:   with(static, "role_arn", "arn:aws:iam::123456789012:role/login", "sts_endpoint", sts.URL),
+			wantURL:  sts.URL,
+			wantCred: "Credential=ASIAROLE/",
+			explain:  []string{"Assumed role:       arn:aws:iam::123456789012:role/login (session vauth)"},
+		},
+		{
+			name:      "ec2",
+			params:    map[string]string{"role": "app", "type": "ec2", "metadata_url": imds.URL, "nonce": "n"},
+			wantPKCS7: "MIAGCSqG",
+			wantNonce: "n",
+			explain:   []string{"Identity document:  PKCS7 from " + imds.URL + " (IMDSv2)"},
+		},
+		{
+			name:      "ec2 IMDSv1",
+			params:    map[string]string{"role": "app", "type": "ec2", "metadata_url": imdsV1.URL + "/"},
+			wantPKCS7: "MIAGCSqG",
+			explain:   []string{"(IMDSv1)"},
+		},
+		{name: "unknown profile", params: map[string]string{"role": "app", "profile": "none"}, wantErr: "AWS credentials"},
+		{name: "assume role denied", params: with(static, "role_arn", "arn", "sts_endpoint", vault.URL+"/none"), wantErr: "error assuming the role arn"},
+		{name: "no metadata service", params: map[string]string{"type": "ec2", "metadata_url": vault.URL + "/none"}, wantErr: "identity document"},
+		{name: "unsupported type", params: map[string]string{"type": "ecs"}, wantErr: "unsupported type"},
+	}
+	for _, tt := range awsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := vault.Client("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var explain bytes.Buffer
+			_, err = Authenticate(context.Background(), Options{
+				Method:  "aws",
+				Params:  tt.params,
+				Client:  client,
+				Explain: &explain,
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if login["role"] != "app" {
+				t.Fatalf("got role %v, want app", login["role"])
+			}
+			for _, want := range tt.explain {
+				if !strings.Contains(explain.String(), want) {
+					t.Fatalf("got explain %q, want %q", explain.String(), want)
+				}
+			}
+			for _, secret := range []string{"static-secret", "dev-secret", "role-secret", "role-session"} {
+				if strings.Contains(explain.String(), secret) {
+					t.Fatalf("explain %q contains the secret %s", explain.String(), secret)
+				}
+			}
+			if tt.wantPKCS7 != "" {
+				if login["pkcs7"] != tt.wantPKCS7 || (tt.wantNonce != "" && login["nonce"] != tt.wantNonce) {
+					t.Fatalf("got %v, want pkcs7 %s and nonce %s", login, tt.wantPKCS7, tt.wantNonce)
+				}
+				return
+			}
+
+			u, headers := decodeIAMRequest(t, login)
+			if !strings.HasPrefix(u, tt.wantURL) {
+				t.Fatalf("got url %s, want %s", u, tt.wantURL)
+			}
+			if got := headers.Get("Authorization"); !strings.Contains(got, tt.wantCred) {
+				t.Fatalf("got Authorization %s, want %s", got, tt.wantCred)
+			}
+			if got := headers.Get("X-Vault-AWS-IAM-Server-ID"); got != tt.wantHeader {
+				t.Fatalf("got server ID %q, want %q", got, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestAuthenticateExplainUnsupported(t *testing.T) {
+	_, err := Authenticate(context.Background(), Options{Method: "userpass", Explain: ioutil.Discard})
+	if err == nil || !strings.Contains(err.Error(), "explain is not supported") {
+		t.Fatalf("got %v, want explain not supported", err)
+	}
+}
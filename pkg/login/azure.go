@@ -0,0 +1,161 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// DefaultAzureMetadataURL is the address of the Azure Instance Metadata
+	// Service.
+	DefaultAzureMetadataURL = "http://169.254.169.254"
+	// DefaultAzureResource is the resource of the managed identity tokens,
+	// the default of the azure auth method.
+	DefaultAzureResource = "https://management.azure.com/"
+)
+
+// AzureHandler logs in through an azure auth method with a token of the
+// managed identity of the virtual machine, read from the Instance Metadata
+// Service (IMDS).
+type AzureHandler struct{}
+
+// azureCompute is the compute metadata of the virtual machine.
+type azureCompute struct {
+	Name              string `json:"name"`
+	ResourceGroupName string `json:"resourceGroupName"`
+	SubscriptionID    string `json:"subscriptionId"`
+	VMScaleSetName    string `json:"vmScaleSetName"`
+}
+
+// Auth implements Handler.
+func (h *AzureHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, error) {
+	return h.AuthContext(context.Background(), c, m)
+}
+
+// AuthContext implements ContextHandler.
+func (h *AzureHandler) AuthContext(ctx context.Context, c *api.Client, m map[string]string) (*api.Secret, error) {
+	if m["role"] == "" {
+		return nil, fmt.Errorf("'role' not supplied")
+	}
+	metadataURL := strings.TrimSuffix(m["metadata_url"], "/")
+	if metadataURL == "" {
+		metadataURL = DefaultAzureMetadataURL
+	}
+	resource := m["resource"]
+	if resource == "" {
+		resource = DefaultAzureResource
+	}
+
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", resource)
+	if clientID := m["client_id"]; clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	if objectID := m["object_id"]; objectID != "" {
+		query.Set("object_id", objectID)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := azureMetadata(ctx, metadataURL+"/metadata/identity/oauth2/token?"+query.Encode(), &token); err != nil {
+		return nil, fmt.Errorf("error requesting the managed identity token: %s", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("empty managed identity token")
+	}
+
+	var instance struct {
+		Compute azureCompute `json:"compute"`
+	}
+	if err := azureMetadata(ctx, metadataURL+"/metadata/instance?api-version=2017-08-01", &instance); err != nil {
+		return nil, fmt.Errorf("error reading the instance metadata: %s", err)
+	}
+
+	data := map[string]interface{}{
+		"role":                m["role"],
+		"jwt":                 token.AccessToken,
+		"subscription_id":     instance.Compute.SubscriptionID,
+		"resource_group_name": instance.Compute.ResourceGroupName,
+	}
+	if instance.Compute.VMScaleSetName != "" {
+		data["vmss_name"] = instance.Compute.VMScaleSetName
+	} else {
+		data["vm_name"] = instance.Compute.Name
+	}
+	for _, k := range []string{"subscription_id", "resource_group_name", "vm_name", "vmss_name"} {
+		if v := m[k]; v != "" {
+			data[k] = v
+		}
+	}
+
+	mount := m["mount"]
+	if mount == "" {
+		mount = "azure"
+	}
+	return writeLogin(c, mount, data)
+}
+
+// azureMetadata decodes into v the IMDS response at u.
+func azureMetadata(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Metadata", "true")
+	body, err := doExternal(ctx, req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// Help implements Handler.
+func (h *AzureHandler) Help() string {
+	help := `
+Usage: vauth login -m azure [CONFIG K=V...]
+
+  The azure method logs in through an azure auth method with a token of the
+  managed identity of the virtual machine, read from the Azure Instance
+  Metadata Service with the subscription, resource group and virtual machine
+  name.
+
+  Authenticate with the "app" role:
+
+      $ vauth login -m azure role=app
+
+Configuration:
+
+  client_id=<string>
+      Client ID of the user-assigned managed identity to use. Defaults to
+      the system-assigned identity.
+
+  metadata_url=<string>
+      Address of the Instance Metadata Service. Defaults to
+      http://169.254.169.254.
+
+  mount=<string>
+      Path where the azure auth method is mounted. Defaults to "azure".
+
+  object_id=<string>
+      Object ID of the user-assigned managed identity to use.
+
+  resource=<string>
+      Resource of the token, it must match the resource of the auth method.
+      Defaults to https://management.azure.com/.
+
+  role=<string>
+      Role of the azure auth method. This is required.
+
+  subscription_id, resource_group_name, vm_name, vmss_name=<string>
+      Override the values read from the instance metadata.
+`
+
+	return strings.TrimSpace(help)
+}
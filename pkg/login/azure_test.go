@@ -0,0 +1,116 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+// newIMDS returns a stand-in for the Azure Instance Metadata Service of a
+// virtual machine, optionally in the scale set vmss.
+func newIMDS(vmss string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/identity/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			http.Error(w, "missing Metadata header", http.StatusBadRequest)
+			return
+		}
+		token := "token-for-" + r.URL.Query().Get("resource")
+		if clientID := r.URL.Query().Get("client_id"); clientID != "" {
+			token += "-" + clientID
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": token})
+	})
+	mux.HandleFunc("/metadata/instance", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"compute": map[string]string{
+				"name":              "vm-1",
+				"resourceGroupName": "rg",
+				"subscriptionId":    "sub",
+				"vmScaleSetName":    vmss,
+			},
+		})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestAzureLogin(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	var login map[string]interface{}
+	vault.HandleFunc("/v1/auth/azure/login", func(w http.ResponseWriter, r *http.Request) {
+		login = nil
+		json.NewDecoder(r.Body).Decode(&login)
+		if login["jwt"] != "token-for-"+DefaultAzureResource {
+			vaulttest.RespondError(w, http.StatusBadRequest, "invalid jwt")
+			return
+		}
+		token := vault.CreateToken("app")
+		vaulttest.RespondJSON(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token.ID, "lease_duration": 3600},
+		})
+	})
+	imds := newIMDS("")
+	defer imds.Close()
+	imdsScaleSet := newIMDS("vmss-1")
+	defer imdsScaleSet.Close()
+
+	azureTests := []struct {
+		name    string
+		params  map[string]string
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:   "vm",
+			params: map[string]string{"role": "app", "metadata_url": imds.URL},
+			want:   map[string]interface{}{"role": "app", "subscription_id": "sub", "resource_group_name": "rg", "vm_name": "vm-1"},
+		},
+		{
+			name:   "scale set",
+			params: map[string]string{"role": "app", "metadata_url": imdsScaleSet.URL + "/"},
+			want:   map[string]interface{}{"vmss_name": "vmss-1", "vm_name": nil},
+		},
+		{
+			name:   "overrides",
+			params: map[string]string{"role": "app", "metadata_url": imds.URL, "resource_group_name": "other"},
+			want:   map[string]interface{}{"resource_group_name": "other"},
+		},
+		{name: "missing role", params: map[string]string{"metadata_url": imds.URL}, wantErr: "'role' not supplied"},
+		{name: "other resource", params: map[string]string{"role": "app", "metadata_url": imds.URL, "resource": "https://vault"}, wantErr: "invalid jwt"},
+		{name: "user-assigned identity", params: map[string]string{"role": "app", "metadata_url": imds.URL, "client_id": "id"}, wantErr: "invalid jwt"},
+		{name: "no IMDS", params: map[string]string{"role": "app", "metadata_url": vault.URL + "/none"}, wantErr: "managed identity token"},
+	}
+	for _, tt := range azureTests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := vault.Client("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Authenticate(context.Background(), Options{
+				Method: "azure",
+				Params: tt.params,
+				Client: client,
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range tt.want {
+				if got := login[k]; got != want {
+					t.Fatalf("%s: got %v, want %v", k, got, want)
+				}
+			}
+		})
+	}
+}
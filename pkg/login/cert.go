@@ -0,0 +1,162 @@
+package login
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// CertHandler logs in through a cert auth method with the client
+// certificate of the TLS connection: the VAULT_CLIENT_CERT and
+// VAULT_CLIENT_KEY files, or a PKCS#12 bundle loaded in memory.
+type CertHandler struct{}
+
+// Auth implements Handler.
+func (h *CertHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, error) {
+	mount := m["mount"]
+	if mount == "" {
+		mount = "cert"
+	}
+	return writeLogin(c, mount, map[string]interface{}{
+		"name": m["name"],
+	})
+}
+
+// ClientCertificate implements CertificateHandler: it returns the
+// certificate and key of the client_p12 bundle, decrypted with the password
+// in p12_password_file, or nil without a bundle.
+func (h *CertHandler) ClientCertificate(m map[string]string) (*tls.Certificate, error) {
+	if m["client_p12"] == "" {
+		return nil, nil
+	}
+	if os.Getenv(api.EnvVaultClientCert) != "" {
+		return nil, fmt.Errorf("client_p12 cannot be used together with %s", api.EnvVaultClientCert)
+	}
+	bundle, err := ioutil.ReadFile(m["client_p12"])
+	if err != nil {
+		return nil, fmt.Errorf("error reading the PKCS#12 bundle: %s", err)
+	}
+	var password string
+	if path := m["p12_password_file"]; path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the PKCS#12 password: %s", err)
+		}
+		password = strings.TrimRight(string(b), "\r\n")
+	}
+	return decodeP12(bundle, password)
+}
+
+// decodeP12 returns the client certificate, with its chain, and the key of
+// a PKCS#12 bundle. The PEM encoding is never written to disk.
+func decodeP12(bundle []byte, password string) (*tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(bundle, password)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the PKCS#12 bundle: %s (bundles using AES need to be exported with the legacy 3DES encryption, e.g. openssl pkcs12 -export -legacy)", err)
+	}
+	var certs [][]byte
+	var key []byte
+	for _, b := range blocks {
+		// Drop the bag attributes, not part of the PEM format
+		b.Headers = nil
+		if b.Type == "CERTIFICATE" {
+			certs = append(certs, pem.EncodeToMemory(b))
+		} else if key == nil {
+			key = pem.EncodeToMemory(b)
+		}
+	}
+	if key == nil || len(certs) == 0 {
+		return nil, fmt.Errorf("the PKCS#12 bundle has no certificate and key")
+	}
+
+	// The leaf, matching the key, comes first followed by the chain
+	for i := range certs {
+		chain := append([][]byte{certs[i]}, certs[:i]...)
+		chain = append(chain, certs[i+1:]...)
+		cert, err := tls.X509KeyPair(joinPEM(chain), key)
+		if err == nil {
+			return &cert, nil
+		}
+	}
+	return nil, fmt.Errorf("no certificate of the PKCS#12 bundle matches its key")
+}
+
+// joinPEM concatenates PEM encoded blocks.
+func joinPEM(blocks [][]byte) []byte {
+	var out []byte
+	for _, b := range blocks {
+		out = append(out, b...)
+	}
+	return out
+}
+
+// withClientCertificate returns a copy of config presenting cert in the TLS
+// handshakes with Vault. The config, its transport and its TLS config are
+// cloned: the caller one is left untouched and may be reused.
+func withClientCertificate(config *api.Config, cert *tls.Certificate) (*api.Config, error) {
+	transport, ok := config.HttpClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("the client certificate requires an *http.Transport")
+	}
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return cert, nil
+	}
+	httpClient := *config.HttpClient
+	httpClient.Transport = transport
+	config = copyConfig(config)
+	config.HttpClient = &httpClient
+	return config, nil
+}
+
+// Help implements Handler.
+func (h *CertHandler) Help() string {
+	help := `
+Usage: vauth login -m cert [CONFIG K=V...]
+
+  The certificate auth method allows users to authenticate with a
+  client certificate passed with the request. The certificate is read from
+  the VAULT_CLIENT_CERT and VAULT_CLIENT_KEY PEM files or, with client_p12,
+  from a PKCS#12 bundle: the certificate and the key are extracted in
+  memory and never written to disk.
+
+  Authenticate using the VAULT_CLIENT_CERT and VAULT_CLIENT_KEY files:
+
+      $ VAULT_CLIENT_CERT=cert.pem VAULT_CLIENT_KEY=key.pem vauth login -m cert
+
+  Authenticate using a PKCS#12 bundle against the "web" certificate role:
+
+      $ vauth login -m cert --cert-name web client_p12=client.p12 \
+          p12_password_file=/run/secrets/p12-password
+
+Configuration:
+
+  client_p12=<string>
+      Path of a PKCS#12 (.p12 or .pfx) bundle with the client certificate,
+      its chain and its key. The bundle must use the legacy 3DES or RC2
+      encryption.
+
+  mount=<string>
+      Path where the cert auth method is mounted. Defaults to "cert".
+
+  name=<string>
+      Certificate role to authenticate against, also set by --cert-name.
+      Defaults to any role trusting the certificate.
+
+  p12_password_file=<string>
+      Path of the file holding the password of the client_p12 bundle, the
+      trailing newline is ignored. Defaults to an empty password.
+`
+
+	return strings.TrimSpace(help)
+}
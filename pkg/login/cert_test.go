@@ -0,0 +1,120 @@
+package login
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestDecodeP12(t *testing.T) {
+	bundle, err := ioutil.ReadFile("testdata/client.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeP12(bundle, "wrong"); err == nil || !strings.Contains(err.Error(), "error decoding the PKCS#12 bundle") {
+		t.Fatalf("got %v, want a decoding error", err)
+	}
+	cert, err := decodeP12(bundle, "vauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("got %d certificates, want the leaf and the CA", len(cert.Certificate))
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "vauth-client" {
+		t.Fatalf("got leaf %s, want vauth-client", leaf.Subject.CommonName)
+	}
+}
+
+func TestCertLoginP12(t *testing.T) {
+	defer setenv(map[string]string{"VAULT_ADDR": "", "VAULT_CLIENT_CERT": ""})()
+	dir, err := ioutil.TempDir("", "vauth-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("vauth\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var login map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&login)
+		if r.URL.Path != "/v1/auth/cert/login" || len(r.TLS.PeerCertificates) == 0 ||
+			r.TLS.PeerCertificates[0].Subject.CommonName != "vauth-client" || login["name"] != "web" {
+			vaulttest.RespondError(w, http.StatusBadRequest, "invalid certificate")
+			return
+		}
+		vaulttest.RespondJSON(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "s.cert", "lease_duration": 3600},
+		})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	certTests := []struct {
+		name    string
+		params  map[string]string
+		client  bool
+		wantErr string
+	}{
+		{name: "p12", params: map[string]string{"name": "web", "client_p12": "testdata/client.p12", "p12_password_file": passwordFile}},
+		{name: "no certificate", params: map[string]string{"name": "web"}, wantErr: "invalid certificate"},
+		{name: "wrong password", params: map[string]string{"client_p12": "testdata/client.p12"}, wantErr: "error decoding the PKCS#12 bundle"},
+		{name: "missing bundle", params: map[string]string{"client_p12": "testdata/none.p12"}, wantErr: "error reading the PKCS#12 bundle"},
+		{name: "external client", params: map[string]string{"client_p12": "testdata/client.p12", "p12_password_file": passwordFile}, client: true, wantErr: "client built by Authenticate"},
+	}
+	for _, tt := range certTests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := api.DefaultConfig()
+			config.Address = server.URL
+			config.MaxRetries = 0
+			if err := config.ConfigureTLS(&api.TLSConfig{Insecure: true}); err != nil {
+				t.Fatal(err)
+			}
+			opts := Options{Method: "cert", Params: tt.params, Config: config}
+			if tt.client {
+				if opts.Client, err = api.NewClient(config); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sec, err := Authenticate(context.Background(), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sec.Auth.ClientToken != "s.cert" {
+				t.Fatalf("got token %s, want s.cert", sec.Auth.ClientToken)
+			}
+			// The caller config must not present the certificate
+			if tlsConfig := config.HttpClient.Transport.(*http.Transport).TLSClientConfig; tlsConfig.GetClientCertificate != nil {
+				t.Fatal("the client certificate was set on the caller config")
+			}
+			if _, err := Authenticate(context.Background(), opts); err != nil {
+				t.Fatalf("second login with the same config: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// DetectMethod returns the auth method matching the CI environment vauth runs
+// in: gha inside GitHub Actions jobs allowed to request an ID token, gitlab
+// inside GitLab CI jobs, otherwise the empty string.
+func DetectMethod() string {
+	if os.Getenv("GITHUB_ACTIONS") == "true" && os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL") != "" {
+		return "gha"
+	}
+	if os.Getenv("GITLAB_CI") == "true" {
+		return "gitlab"
+	}
+	return ""
+}
+
+// GitHubActionsHandler logs in through a jwt auth method with the OIDC ID
+// token minted by GitHub Actions for the running job.
+type GitHubActionsHandler struct{}
+
+// Auth implements Handler.
+func (h *GitHubActionsHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, error) {
+	return h.AuthContext(context.Background(), c, m)
+}
+
+// AuthContext implements ContextHandler.
+func (h *GitHubActionsHandler) AuthContext(ctx context.Context, c *api.Client, m map[string]string) (*api.Secret, error) {
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return nil, fmt.Errorf("ACTIONS_ID_TOKEN_REQUEST_URL and ACTIONS_ID_TOKEN_REQUEST_TOKEN not set, " +
+			"the job needs the \"id-token: write\" permission")
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACTIONS_ID_TOKEN_REQUEST_URL: %s", err)
+	}
+	if audience := m["audience"]; audience != "" {
+		q := u.Query()
+		q.Set("audience", audience)
+		u.RawQuery = q.Encode()
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := doExternal(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting the GitHub Actions ID token: %s", err)
+	}
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(resp, &body); err != nil {
+		return nil, fmt.Errorf("error decoding the GitHub Actions ID token: %s", err)
+	}
+	if body.Value == "" {
+		return nil, fmt.Errorf("empty GitHub Actions ID token")
+	}
+
+	return jwtLogin(c, m, body.Value)
+}
+
+// Help implements Handler.
+func (h *GitHubActionsHandler) Help() string {
+	help := `
+Usage: vauth login -m gha [CONFIG K=V...]
+
+  The gha method logs in through a jwt auth method with the OIDC ID token
+  minted by GitHub Actions for the running job. The job needs the
+  "id-token: write" permission. The method is selected automatically when
+  vauth runs inside GitHub Actions and no method is provided.
+
+  Authenticate with the "deploy" role of the jwt auth method at "github-oidc":
+
+      $ vauth login -m gha role=deploy mount=github-oidc audience=https://vault.example.com
+
+Configuration:
+
+  audience=<string>
+      Audience of the ID token, it must match the bound_audiences of the
+      role. Defaults to the GitHub default audience.
+
+  mount=<string>
+      Path where the jwt auth method is mounted. Defaults to "jwt".
+
+  role=<string>
+      Role of the jwt auth method. Defaults to the default_role of the
+      auth method.
+`
+
+	return strings.TrimSpace(help)
+}
+
+// GitLabHandler logs in through a jwt auth method with the ID token of the
+// running GitLab CI job.
+type GitLabHandler struct{}
+
+// Auth implements Handler.
+func (h *GitLabHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, error) {
+	name := m["token_var"]
+	if name == "" {
+		name = "CI_JOB_JWT_V2"
+	}
+	jwt := strings.TrimSpace(os.Getenv(name))
+	if jwt == "" {
+		return nil, fmt.Errorf("%s not set, configure the job id_tokens and pass its name with token_var", name)
+	}
+	return jwtLogin(c, m, jwt)
+}
+
+// Help implements Handler.
+func (h *GitLabHandler) Help() string {
+	help := `
+Usage: vauth login -m gitlab [CONFIG K=V...]
+
+  The gitlab method logs in through a jwt auth method with the ID token of
+  the running GitLab CI job. The method is selected automatically when vauth
+  runs inside GitLab CI and no method is provided.
+
+  Authenticate with the ID token declared in the job id_tokens as
+  VAULT_ID_TOKEN:
+
+      $ vauth login -m gitlab role=deploy token_var=VAULT_ID_TOKEN
+
+Configuration:
+
+  mount=<string>
+      Path where the jwt auth method is mounted. Defaults to "jwt".
+
+  role=<string>
+      Role of the jwt auth method. Defaults to the default_role of the
+      auth method.
+
+  token_var=<string>
+      Environment variable holding the ID token. Defaults to CI_JOB_JWT_V2.
+`
+
+	return strings.TrimSpace(help)
+}
+
+// jwtLogin logs in through the jwt auth method at the mount in m.
+func jwtLogin(c *api.Client, m map[string]string, jwt string) (*api.Secret, error) {
+	mount := m["mount"]
+	if mount == "" {
+		mount = "jwt"
+	}
+	data := map[string]interface{}{
+		"jwt": jwt,
+	}
+	if role := m["role"]; role != "" {
+		data["role"] = role
+	}
+	return writeLogin(c, mount, data)
+}
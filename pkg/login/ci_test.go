@@ -0,0 +1,145 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+// setenv sets the environment variables in vars and returns a function
+// restoring them.
+func setenv(vars map[string]string) func() {
+	old := make(map[string]string)
+	for k, v := range vars {
+		old[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k, v := range old {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func TestDetectMethod(t *testing.T) {
+	detectTests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "github actions", env: map[string]string{"GITHUB_ACTIONS": "true", "ACTIONS_ID_TOKEN_REQUEST_URL": "http://localhost", "GITLAB_CI": ""}, want: "gha"},
+		{name: "github actions without id-token", env: map[string]string{"GITHUB_ACTIONS": "true", "ACTIONS_ID_TOKEN_REQUEST_URL": "", "GITLAB_CI": ""}, want: ""},
+		{name: "gitlab", env: map[string]string{"GITHUB_ACTIONS": "", "GITLAB_CI": "true"}, want: "gitlab"},
+		{name: "none", env: map[string]string{"GITHUB_ACTIONS": "", "GITLAB_CI": ""}, want: ""},
+	}
+	for _, tt := range detectTests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setenv(tt.env)()
+			if got := DetectMethod(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubActionsLogin(t *testing.T) {
+	// Fake GitHub Actions token endpoint
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"value":"gha-jwt-%s"}`, r.URL.Query().Get("audience"))
+	}))
+	defer tokens.Close()
+
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddJWTRole("github-oidc", "deploy", "gha-jwt-https://vault.example.com", "deploy")
+
+	ghaTests := []struct {
+		name         string
+		requestToken string
+		params       map[string]string
+		wantErr      string
+	}{
+		{name: "success", requestToken: "request-token", params: map[string]string{"role": "deploy", "audience": "https://vault.example.com"}},
+		{name: "wrong audience", requestToken: "request-token", params: map[string]string{"role": "deploy", "audience": "other"}, wantErr: "invalid signature"},
+		{name: "unauthorized", requestToken: "wrong", params: map[string]string{"role": "deploy"}, wantErr: "401 Unauthorized"},
+		{name: "missing permission", requestToken: "", params: map[string]string{"role": "deploy"}, wantErr: "id-token: write"},
+	}
+	for _, tt := range ghaTests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setenv(map[string]string{
+				"ACTIONS_ID_TOKEN_REQUEST_URL":   tokens.URL + "/token?api-version=2.0",
+				"ACTIONS_ID_TOKEN_REQUEST_TOKEN": tt.requestToken,
+			})()
+			client, err := vault.Client("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sec, err := Authenticate(context.Background(), Options{
+				Method: "gha",
+				Mount:  "github-oidc",
+				Params: tt.params,
+				Client: client,
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sec.Auth.Policies[0] != "deploy" {
+				t.Errorf("got policies %v", sec.Auth.Policies)
+			}
+		})
+	}
+}
+
+func TestGitLabLogin(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddJWTRole("jwt", "deploy", "gitlab-jwt", "deploy")
+
+	gitlabTests := []struct {
+		name    string
+		env     map[string]string
+		params  map[string]string
+		wantErr string
+	}{
+		{name: "CI_JOB_JWT_V2", env: map[string]string{"CI_JOB_JWT_V2": "gitlab-jwt"}, params: map[string]string{"role": "deploy"}},
+		{name: "id_tokens", env: map[string]string{"CI_JOB_JWT_V2": "", "VAULT_ID_TOKEN": "gitlab-jwt"}, params: map[string]string{"role": "deploy", "token_var": "VAULT_ID_TOKEN"}},
+		{name: "missing token", env: map[string]string{"CI_JOB_JWT_V2": ""}, params: map[string]string{"role": "deploy"}, wantErr: "CI_JOB_JWT_V2 not set"},
+		{name: "unknown role", env: map[string]string{"CI_JOB_JWT_V2": "gitlab-jwt"}, params: map[string]string{"role": "other"}, wantErr: "could not be found"},
+	}
+	for _, tt := range gitlabTests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setenv(tt.env)()
+			client, err := vault.Client("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Authenticate(context.Background(), Options{
+				Method: "gitlab",
+				Params: tt.params,
+				Client: client,
+			})
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
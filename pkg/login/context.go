@@ -0,0 +1,122 @@
+package login
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+)
+
+// NewClientWithContext return a new vault client like NewClient whose
+// requests are all bound to ctx: cancelling ctx aborts the in-flight
+// requests.
+func NewClientWithContext(ctx context.Context, config *api.Config) (*api.Client, error) {
+	return newClient(ctx, config, RetryPolicy{}, nil)
+}
+
+// NewClientWithLogger return a new vault client like NewClientWithContext
+// logging its requests to logger.
+func NewClientWithLogger(ctx context.Context, config *api.Config, logger hclog.Logger) (*api.Client, error) {
+	return newClient(ctx, config, RetryPolicy{}, logger)
+}
+
+// newClient return a new vault client whose requests are bound to ctx,
+// retried according to retry and logged to logger, if not nil.
+func newClient(ctx context.Context, config *api.Config, retry RetryPolicy, logger hclog.Logger) (*api.Client, error) {
+	if config == nil {
+		config = api.DefaultConfig()
+	}
+	// The client keeps and modifies its config: never change the caller one,
+	// that may be used to build more clients.
+	config = copyConfig(config)
+	client, err := NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	// The transport is wrapped once the client is built since api.NewClient
+	// expects an *http.Transport for unix socket addresses.
+	httpClient := *config.HttpClient
+	if logger != nil {
+		httpClient.Transport = &logTransport{logger: logger, base: httpClient.Transport}
+	}
+	if retry.Max > 0 {
+		// Replace the Vault client retries, that ignore 429 responses
+		client.SetMaxRetries(0)
+		httpClient.Transport = &retryTransport{policy: retry, base: httpClient.Transport, logger: logger}
+	}
+	httpClient.Transport = &contextTransport{ctx: ctx, base: httpClient.Transport}
+	config.HttpClient = &httpClient
+	return client, nil
+}
+
+// copyConfig returns a shallow copy of config. The exported fields are copied
+// one by one since the config embeds a lock.
+func copyConfig(config *api.Config) *api.Config {
+	return &api.Config{
+		Address:      config.Address,
+		AgentAddress: config.AgentAddress,
+		HttpClient:   config.HttpClient,
+		MaxRetries:   config.MaxRetries,
+		Timeout:      config.Timeout,
+		Error:        config.Error,
+		Backoff:      config.Backoff,
+		Limiter:      config.Limiter,
+	}
+}
+
+// contextTransport is an http.RoundTripper that cancels the requests as soon
+// as ctx is done, in addition to the request own context.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	stop := make(chan struct{})
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(stop)
+			cancel()
+		})
+	}
+	go func() {
+		select {
+		case <-t.ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		if t.ctx.Err() != nil {
+			return nil, t.ctx.Err()
+		}
+		return nil, err
+	}
+	// The body is read after RoundTrip returns: keep the context alive
+	// until it gets closed.
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody calls release when the response body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,118 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestAuthenticateContext(t *testing.T) {
+	// The server hangs until the client gives up on the request
+	hung := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hung <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	contextTests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{
+			name: "timeout",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 100*time.Millisecond)
+			},
+			want: context.DeadlineExceeded,
+		},
+		{
+			name: "cancel",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				go func() {
+					<-hung
+					cancel()
+				}()
+				return ctx, cancel
+			},
+			want: context.Canceled,
+		},
+	}
+	for _, tt := range contextTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			config := api.DefaultConfig()
+			config.Address = ts.URL
+			config.MaxRetries = 0
+
+			start := time.Now()
+			_, err := Authenticate(ctx, Options{
+				Method: "token",
+				Params: map[string]string{"token": "foo"},
+				Config: config,
+			})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("cancellation took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestContextTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &http.Client{Transport: &contextTransport{ctx: ctx, base: http.DefaultTransport}}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	cancel()
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Fatal("expected an error once the context is cancelled")
+	}
+}
+
+func TestAuthenticateReusesConfig(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	config := api.DefaultConfig()
+	config.Address = vault.URL
+	config.MaxRetries = 0
+	transport := config.HttpClient.Transport
+
+	for i := 0; i < 2; i++ {
+		// The first context is done once its login returns
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := Authenticate(ctx, Options{
+			Method: "token",
+			Params: map[string]string{"token": vault.RootToken},
+			Config: config,
+			Retry:  RetryPolicy{Max: 1},
+		})
+		cancel()
+		if err != nil {
+			t.Fatalf("login %d: %v", i+1, err)
+		}
+		if config.HttpClient.Transport != transport {
+			t.Fatalf("login %d: got transport %T, want the config one unchanged", i+1, config.HttpClient.Transport)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package login
+
+import "os"
+
+// UsernameFromEnv returns the username stored into LOGNAME or USER if defined or
+// the empty string
+func UsernameFromEnv() string {
+	if logname := os.Getenv("LOGNAME"); logname != "" {
+		return logname
+	}
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return ""
+}
+
+// PasswordFromEnv returns the password stored into PASSWORD or
+// the empty string
+func PasswordFromEnv() string {
+	if password := os.Getenv("PASSWORD"); password != "" {
+		return password
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The kinds of the failures of Authenticate, matched with errors.Is, e.g.
+//
+//	if errors.Is(err, login.ErrAuthDenied) {
+//		// bad credentials, do not retry
+//	}
+var (
+	// ErrUsage is an invalid option or parameter.
+	ErrUsage = errors.New("invalid usage")
+	// ErrAuthDenied is a login rejected by Vault, e.g. a wrong password.
+	ErrAuthDenied = errors.New("authentication denied")
+	// ErrUnsupportedMethod is an auth method without a handler.
+	ErrUnsupportedMethod = errors.New("unsupported auth method")
+	// ErrUnavailable is a network failure or a Vault server error.
+	ErrUnavailable = errors.New("vault unavailable")
+	// ErrSealed is a Vault server sealed.
+	ErrSealed = errors.New("vault sealed")
+	// ErrPersist is a token obtained but not stored in the TokenSink.
+	ErrPersist = errors.New("token not persisted")
+	// ErrTimeout is a login not completed before the context deadline.
+	ErrTimeout = errors.New("timeout")
+)
+
+// Error is a failure of Authenticate of a known kind. It matches its Kind
+// with errors.Is and unwraps to the underlying error, e.g. to
+// context.DeadlineExceeded for ErrTimeout.
+type Error struct {
+	// Kind is one of the Err* errors of this package.
+	Kind error
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of e.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// newError returns an Error of kind formatted according to format.
+func newError(kind error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+// statusPattern matches the status code of the errors of the Vault API
+// client, often flattened into strings by the auth handlers.
+var statusPattern = regexp.MustCompile(`Code: (\d{3})\.`)
+
+// classifyError wraps err, returned by a request to Vault, into an Error of
+// the matching kind. Unknown errors and context.Canceled are returned as
+// they are.
+func classifyError(err error) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Kind: ErrTimeout, Err: err}
+	}
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	msg := err.Error()
+	if m := statusPattern.FindStringSubmatch(msg); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		switch {
+		case status == 503 && strings.Contains(msg, "sealed"):
+			return &Error{Kind: ErrSealed, Err: err}
+		case status == 400 || status == 401 || status == 403:
+			return &Error{Kind: ErrAuthDenied, Err: err}
+		case status == 429 || status >= 500:
+			return &Error{Kind: ErrUnavailable, Err: err}
+		}
+		return err
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) || strings.Contains(msg, "dial tcp") {
+		return &Error{Kind: ErrUnavailable, Err: err}
+	}
+	return err
+}
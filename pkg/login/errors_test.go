@@ -0,0 +1,112 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+// failingSink is a TokenSink failing to store the tokens.
+type failingSink struct{}
+
+var errDiskFull = errors.New("disk full")
+
+func (failingSink) Store(token string) error {
+	return errDiskFull
+}
+
+func TestAuthenticateErrors(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddUser("userpass", "alice", "secret")
+	vault.HandleFunc("/v1/auth/broken/login/alice", func(w http.ResponseWriter, r *http.Request) {
+		vaulttest.RespondError(w, http.StatusInternalServerError, "internal error")
+	})
+	vault.HandleFunc("/v1/auth/tokenless/login/alice", func(w http.ResponseWriter, r *http.Request) {
+		vaulttest.RespondJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{}})
+	})
+	sealed := vaulttest.NewServer()
+	defer sealed.Close()
+	sealed.SetSealed(true)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	errorTests := []struct {
+		name    string
+		address string
+		opts    Options
+		want    error
+	}{
+		{name: "wrong password", address: vault.URL, opts: Options{Method: "userpass", Params: map[string]string{"username": "alice", "password": "wrong"}}, want: ErrAuthDenied},
+		{name: "unknown method", address: vault.URL, opts: Options{Method: "foo"}, want: ErrUnsupportedMethod},
+		{name: "explain", address: vault.URL, opts: Options{Method: "userpass", Explain: &bytes.Buffer{}}, want: ErrUsage},
+		{name: "server error", address: vault.URL, opts: Options{Method: "userpass", Mount: "broken", Params: map[string]string{"username": "alice", "password": "secret"}}, want: ErrUnavailable},
+		{name: "no token", address: vault.URL, opts: Options{Method: "userpass", Mount: "tokenless", Params: map[string]string{"username": "alice", "password": "secret"}}, want: ErrAuthDenied},
+		{name: "connection refused", address: down.URL, opts: Options{Method: "token", Params: map[string]string{"token": "foo"}}, want: ErrUnavailable},
+		{name: "sealed", address: sealed.URL, opts: Options{Method: "token", Params: map[string]string{"token": "foo"}}, want: ErrSealed},
+		{name: "persist", address: vault.URL, opts: Options{Method: "userpass", Params: map[string]string{"username": "alice", "password": "secret"}, TokenSink: failingSink{}}, want: ErrPersist},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := api.DefaultConfig()
+			config.Address = tt.address
+			config.MaxRetries = 0
+			tt.opts.Config = config
+
+			_, err := Authenticate(context.Background(), tt.opts)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			var e *Error
+			if !errors.As(err, &e) || e.Kind != tt.want {
+				t.Fatalf("got %#v, want an *Error of kind %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateErrorUnwrap(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddUser("userpass", "alice", "secret")
+	client, err := vault.Client("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec, err := Authenticate(context.Background(), Options{
+		Method:    "userpass",
+		Params:    map[string]string{"username": "alice", "password": "secret"},
+		Client:    client,
+		TokenSink: failingSink{},
+	})
+	if sec == nil || !errors.Is(err, ErrPersist) || !errors.Is(err, errDiskFull) {
+		t.Fatalf("got %v, want the secret and %v wrapping %v", err, ErrPersist, errDiskFull)
+	}
+	// The kind survives further wrapping by the callers
+	if wrapped := fmt.Errorf("login: %w", err); !errors.Is(wrapped, ErrPersist) {
+		t.Fatalf("got %v, want %v", wrapped, ErrPersist)
+	}
+
+	vault.SetSealed(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Authenticate(ctx, Options{
+		Method:        "token",
+		Params:        map[string]string{"token": "foo"},
+		Client:        client,
+		WaitForUnseal: true,
+		Retry:         RetryPolicy{MaxWait: 10 * time.Millisecond},
+	})
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v wrapping %v", err, ErrTimeout, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,50 @@
+package login_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/vault/api"
+	vt "github.com/mauromedda/vauth/command/token"
+	"github.com/mauromedda/vauth/pkg/login"
+)
+
+// Authenticate with the userpass method and persist the token in
+// ~/.vault-token as the vauth CLI does.
+func ExampleAuthenticate() {
+	tokenHelper := &vt.InternalTokenHelper{}
+	tokenHelper.PopulateTokenPath()
+
+	secret, err := login.Authenticate(context.Background(), login.Options{
+		Method: "userpass",
+		Params: map[string]string{
+			"username": "test",
+			"password": "test",
+		},
+		TokenSink: tokenHelper,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(secret.Auth.Policies)
+}
+
+// Authenticate with a token against a custom Vault address and a
+// non-default mount, without storing the token anywhere.
+func ExampleAuthenticate_config() {
+	config := api.DefaultConfig()
+	config.Address = "https://vault.mycompany.com:8200"
+
+	secret, err := login.Authenticate(context.Background(), login.Options{
+		Method: "token",
+		Mount:  "token",
+		Params: map[string]string{"token": "s.oXsX8GqsYxyvXmtkjpT8fLhU"},
+		Config: config,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokenID, _ := secret.TokenID()
+	fmt.Println(tokenID)
+}
@@ -0,0 +1,343 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// DefaultGCPMetadataURL is the address of the GCE metadata server.
+	DefaultGCPMetadataURL = "http://metadata.google.internal"
+	// DefaultGCPIAMURL is the address of the IAM Service Account Credentials
+	// API signing the JWTs of the iam mode.
+	DefaultGCPIAMURL = "https://iamcredentials.googleapis.com"
+	// DefaultGCPTokenURL is the OAuth2 token endpoint exchanging the service
+	// account key for an access token, when the key file has no token_uri.
+	DefaultGCPTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+// GCPHandler logs in through a gcp auth method, in the gce mode with an
+// identity token of the instance from the metadata server, in the iam mode
+// with a JWT signed by the IAM API for a service account.
+type GCPHandler struct{}
+
+// gcpServiceAccountKey is a service account key file.
+type gcpServiceAccountKey struct {
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// Auth implements Handler.
+func (h *GCPHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, error) {
+	return h.AuthContext(context.Background(), c, m)
+}
+
+// AuthContext implements ContextHandler.
+func (h *GCPHandler) AuthContext(ctx context.Context, c *api.Client, m map[string]string) (*api.Secret, error) {
+	role := m["role"]
+	if role == "" {
+		return nil, fmt.Errorf("'role' not supplied")
+	}
+	mode := m["type"]
+	if mode == "" {
+		mode = "gce"
+		if m["credentials"] != "" || os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "" {
+			mode = "iam"
+		}
+	}
+
+	var jwt string
+	var err error
+	switch mode {
+	case "gce":
+		jwt, err = gceIdentityToken(ctx, m)
+	case "iam":
+		jwt, err = iamSignedJWT(ctx, m)
+	default:
+		return nil, fmt.Errorf("unsupported type %q, expected gce or iam", mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mount := m["mount"]
+	if mount == "" {
+		mount = "gcp"
+	}
+	return writeLogin(c, mount, map[string]interface{}{
+		"role": role,
+		"jwt":  jwt,
+	})
+}
+
+// gceIdentityToken returns the identity token of the instance service account
+// for the role, read from the metadata server.
+func gceIdentityToken(ctx context.Context, m map[string]string) (string, error) {
+	metadataURL := strings.TrimSuffix(m["metadata_url"], "/")
+	if metadataURL == "" {
+		metadataURL = DefaultGCPMetadataURL
+	}
+	serviceAccount := m["service_account"]
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	query := url.Values{}
+	query.Set("audience", "http://vault/"+m["role"])
+	query.Set("format", "full")
+	u := fmt.Sprintf("%s/computeMetadata/v1/instance/service-accounts/%s/identity?%s",
+		metadataURL, url.PathEscape(serviceAccount), query.Encode())
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	body, err := doExternal(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting the GCE identity token: %s", err)
+	}
+	jwt := strings.TrimSpace(string(body))
+	if jwt == "" {
+		return "", fmt.Errorf("empty GCE identity token")
+	}
+	return jwt, nil
+}
+
+// iamSignedJWT returns the JWT for the role signed by the IAM API for the
+// service account, authenticated with the service account key in
+// credentials or in the GOOGLE_APPLICATION_CREDENTIALS file.
+func iamSignedJWT(ctx context.Context, m map[string]string) (string, error) {
+	key, err := readServiceAccountKey(m["credentials"])
+	if err != nil {
+		return "", err
+	}
+	serviceAccount := m["service_account"]
+	if serviceAccount == "" {
+		serviceAccount = key.ClientEmail
+	}
+	expiration := 15 * time.Minute
+	if v := m["jwt_exp"]; v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes <= 0 {
+			return "", fmt.Errorf("invalid jwt_exp %q, expected minutes", v)
+		}
+		expiration = time.Duration(minutes) * time.Minute
+	}
+	tokenURL := m["token_url"]
+	if tokenURL == "" {
+		tokenURL = key.TokenURI
+	}
+	if tokenURL == "" {
+		tokenURL = DefaultGCPTokenURL
+	}
+	iamURL := strings.TrimSuffix(m["iam_url"], "/")
+	if iamURL == "" {
+		iamURL = DefaultGCPIAMURL
+	}
+
+	accessToken, err := gcpAccessToken(ctx, key, tokenURL)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"sub": serviceAccount,
+		"aud": "vault/" + m["role"],
+		"exp": time.Now().Add(expiration).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	reqBody, err := json.Marshal(map[string]string{"payload": string(payload)})
+	if err != nil {
+		return "", err
+	}
+	u := fmt.Sprintf("%s/v1/projects/-/serviceAccounts/%s:signJwt", iamURL, url.PathEscape(serviceAccount))
+	req, err := http.NewRequest("POST", u, bytes.NewReader(reqBody))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	body, err := doExternal(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("error signing the JWT for %s: %s", serviceAccount, err)
+	}
+	var signed struct {
+		SignedJWT string `json:"signedJwt"`
+	}
+	if err := json.Unmarshal(body, &signed); err != nil || signed.SignedJWT == "" {
+		return "", fmt.Errorf("error signing the JWT for %s: no signedJwt in the response", serviceAccount)
+	}
+	return signed.SignedJWT, nil
+}
+
+// readServiceAccountKey decodes the service account key JSON in credentials
+// or, if empty, in the GOOGLE_APPLICATION_CREDENTIALS file.
+func readServiceAccountKey(credentials string) (*gcpServiceAccountKey, error) {
+	if credentials == "" {
+		path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if path == "" {
+			return nil, fmt.Errorf("'credentials' not supplied and GOOGLE_APPLICATION_CREDENTIALS not set")
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the service account key: %s", err)
+		}
+		credentials = string(b)
+	}
+	var key gcpServiceAccountKey
+	if err := json.Unmarshal([]byte(credentials), &key); err != nil {
+		return nil, fmt.Errorf("error decoding the service account key: %s", err)
+	}
+	if key.PrivateKey == "" || key.ClientEmail == "" {
+		return nil, fmt.Errorf("the service account key has no private_key or client_email")
+	}
+	return &key, nil
+}
+
+// gcpAccessToken exchanges the service account key for an OAuth2 access
+// token at tokenURL, with the JWT bearer grant.
+func gcpAccessToken(ctx context.Context, key *gcpServiceAccountKey, tokenURL string) (string, error) {
+	now := time.Now()
+	assertion, err := signRS256(key, map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/cloud-platform",
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := doExternal(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting the access token of %s: %s", key.ClientEmail, err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("error requesting the access token of %s: no access_token in the response", key.ClientEmail)
+	}
+	return token.AccessToken, nil
+}
+
+// signRS256 returns the JWT of claims signed with the service account key.
+func signRS256(key *gcpServiceAccountKey, claims map[string]interface{}) (string, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid private_key in the service account key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", fmt.Errorf("invalid private_key in the service account key: %s", err)
+		}
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("the private_key of the service account key is not an RSA key")
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": key.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Help implements Handler.
+func (h *GCPHandler) Help() string {
+	help := `
+Usage: vauth login -m gcp [CONFIG K=V...]
+
+  The gcp method logs in through a gcp auth method. In the gce mode the
+  identity token of the instance service account is read from the metadata
+  server. In the iam mode a JWT for the service account is signed by the IAM
+  API, authenticated with a service account key.
+
+  Authenticate a GCE instance with the "app" role:
+
+      $ vauth login -m gcp role=app
+
+  Authenticate with a service account key:
+
+      $ vauth login -m gcp type=iam role=app credentials=@sa-key.json
+
+Configuration:
+
+  credentials=<string>
+      Service account key JSON of the iam mode, prefix with @ to read a
+      file. Defaults to the GOOGLE_APPLICATION_CREDENTIALS file.
+
+  iam_url=<string>
+      Address of the IAM Service Account Credentials API. Defaults to
+      https://iamcredentials.googleapis.com.
+
+  jwt_exp=<minutes>
+      Expiration of the JWT of the iam mode. Defaults to 15.
+
+  metadata_url=<string>
+      Address of the GCE metadata server. Defaults to
+      http://metadata.google.internal.
+
+  mount=<string>
+      Path where the gcp auth method is mounted. Defaults to "gcp".
+
+  role=<string>
+      Role of the gcp auth method. This is required.
+
+  service_account=<string>
+      Service account to authenticate as. Defaults to the instance default
+      service account in the gce mode, to the key client_email in the iam
+      mode.
+
+  token_url=<string>
+      OAuth2 token endpoint of the iam mode. Defaults to the key token_uri.
+
+  type=<string>
+      Login mode, gce or iam. Defaults to iam when credentials are
+      available, gce otherwise.
+`
+
+	return strings.TrimSpace(help)
+}
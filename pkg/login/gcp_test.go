@@ -0,0 +1,169 @@
+package login
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+// newGCEMetadata returns a stand-in for the GCE metadata server issuing
+// identity tokens named after the service account and the audience.
+func newGCEMetadata() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const prefix = "/computeMetadata/v1/instance/service-accounts/"
+		if r.Header.Get("Metadata-Flavor") != "Google" || !strings.HasPrefix(r.URL.Path, prefix) || !strings.HasSuffix(r.URL.Path, "/identity") {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("format") != "full" {
+			http.Error(w, "invalid format", http.StatusBadRequest)
+			return
+		}
+		sa := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), "/identity")
+		w.Write([]byte(sa + "@" + r.URL.Query().Get("audience") + "\n"))
+	}))
+}
+
+// newGoogleAPIs returns a stand-in for the OAuth2 token endpoint, verifying
+// the assertion with pub, and for the IAM signJwt endpoint, "signing" the
+// payload as signed:<sub>:<aud>.
+func newGoogleAPIs(pub *rsa.PublicKey) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+			http.Error(w, "invalid grant", http.StatusBadRequest)
+			return
+		}
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/v1/projects/-/serviceAccounts/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" || !strings.HasSuffix(r.URL.Path, ":signJwt") {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		var body struct{ Payload string }
+		var claims struct{ Sub, Aud string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if err := json.Unmarshal([]byte(body.Payload), &claims); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"keyId": "k", "signedJwt": "signed:" + claims.Sub + ":" + claims.Aud})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGCPLogin(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	var login map[string]interface{}
+	vault.HandleFunc("/v1/auth/gcp/login", func(w http.ResponseWriter, r *http.Request) {
+		login = nil
+		json.NewDecoder(r.Body).Decode(&login)
+		token := vault.CreateToken("app")
+		vaulttest.RespondJSON(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token.ID, "lease_duration": 3600},
+		})
+	})
+	metadata := newGCEMetadata()
+	defer metadata.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apis := newGoogleAPIs(&key.PublicKey)
+	defer apis.Close()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"private_key_id": "k",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "ci@project.iam.gserviceaccount.com",
+		"token_uri":      apis.URL + "/token",
+	})
+
+	gcpTests := []struct {
+		name    string
+		params  map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "gce",
+			params: map[string]string{"role": "app", "metadata_url": metadata.URL},
+			want:   "default@http://vault/app",
+		},
+		{
+			name:   "gce service account",
+			params: map[string]string{"role": "app", "type": "gce", "metadata_url": metadata.URL + "/", "service_account": "ci@project"},
+			want:   "ci@project@http://vault/app",
+		},
+		{
+			name:   "iam",
+			params: map[string]string{"role": "app", "credentials": string(credentials), "iam_url": apis.URL},
+			want:   "signed:ci@project.iam.gserviceaccount.com:vault/app",
+		},
+		{
+			name:   "iam impersonation",
+			params: map[string]string{"role": "app", "type": "iam", "credentials": string(credentials), "iam_url": apis.URL, "service_account": "app@project"},
+			want:   "signed:app@project:vault/app",
+		},
+		{name: "missing role", params: map[string]string{"metadata_url": metadata.URL}, wantErr: "'role' not supplied"},
+		{name: "unsupported type", params: map[string]string{"role": "app", "type": "gke"}, wantErr: "unsupported type"},
+		{name: "no metadata server", params: map[string]string{"role": "app", "metadata_url": vault.URL + "/none"}, wantErr: "GCE identity token"},
+		{name: "invalid key", params: map[string]string{"role": "app", "credentials": "{}"}, wantErr: "no private_key"},
+		{
+			name:    "denied signing",
+			params:  map[string]string{"role": "app", "credentials": string(credentials), "iam_url": apis.URL, "token_url": apis.URL + "/none"},
+			wantErr: "access token",
+		},
+	}
+	for _, tt := range gcpTests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := vault.Client("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Authenticate(context.Background(), Options{
+				Method: "gcp",
+				Params: tt.params,
+				Client: client,
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if login["role"] != "app" || login["jwt"] != tt.want {
+				t.Fatalf("got %v, want role app and jwt %s", login, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// externalClient is the HTTP client used by the handlers to talk to the
+// services issuing the identity tokens (CI token endpoints, metadata servers).
+var externalClient = &http.Client{Timeout: 30 * time.Second}
+
+// doExternal sends req with the external client bound to ctx and returns the
+// body of a 200 response.
+func doExternal(ctx context.Context, req *http.Request) ([]byte, error) {
+	resp, err := externalClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return body, nil
+}
+
+// writeLogin writes data to the login endpoint of the auth method at mount.
+func writeLogin(c *api.Client, mount string, data map[string]interface{}) (*api.Secret, error) {
+	path := fmt.Sprintf("auth/%s/login", strings.Trim(mount, "/"))
+	secret, err := c.Logical().Write(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("empty response from credential provider")
+	}
+	return secret, nil
+}
@@ -0,0 +1,74 @@
+package login
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// DefaultServiceAccountTokenPath is the path where Kubernetes mounts the
+// service account token of the pod.
+const DefaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// KubernetesHandler logs in through a kubernetes auth method with the
+// service account token of the pod.
+type KubernetesHandler struct{}
+
+// Auth implements Handler.
+func (h *KubernetesHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, error) {
+	if m["role"] == "" {
+		return nil, fmt.Errorf("'role' not supplied")
+	}
+	jwt := m["jwt"]
+	if jwt == "" {
+		path := m["jwt_path"]
+		if path == "" {
+			path = DefaultServiceAccountTokenPath
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the service account token: %s", err)
+		}
+		jwt = strings.TrimSpace(string(b))
+	}
+
+	params := map[string]string{"role": m["role"], "mount": m["mount"]}
+	if params["mount"] == "" {
+		params["mount"] = "kubernetes"
+	}
+	return jwtLogin(c, params, jwt)
+}
+
+// Help implements Handler.
+func (h *KubernetesHandler) Help() string {
+	help := `
+Usage: vauth login -m kubernetes [CONFIG K=V...]
+
+  The kubernetes method logs in through a kubernetes auth method with the
+  service account token of the pod.
+
+  Authenticate with the "app" role:
+
+      $ vauth login -m kubernetes role=app
+
+Configuration:
+
+  jwt=<string>
+      Service account token. Defaults to the content of jwt_path.
+
+  jwt_path=<string>
+      Path of the service account token. Defaults to
+      /var/run/secrets/kubernetes.io/serviceaccount/token.
+
+  mount=<string>
+      Path where the kubernetes auth method is mounted. Defaults to
+      "kubernetes".
+
+  role=<string>
+      Role of the kubernetes auth method. This is required.
+`
+
+	return strings.TrimSpace(help)
+}
@@ -0,0 +1,60 @@
+package login
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mauromedda/vauth/internal/vaulttest"
+)
+
+func TestKubernetesLogin(t *testing.T) {
+	vault := vaulttest.NewServer()
+	defer vault.Close()
+	vault.AddJWTRole("kubernetes", "app", "sa-jwt", "app")
+	vault.AddJWTRole("k8s-prod", "app", "sa-jwt", "app")
+
+	f, err := ioutil.TempFile("", "vauth-sa-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("sa-jwt\n")
+	f.Close()
+
+	kubernetesTests := []struct {
+		name    string
+		params  map[string]string
+		mount   string
+		wantErr string
+	}{
+		{name: "jwt_path", params: map[string]string{"role": "app", "jwt_path": f.Name()}},
+		{name: "jwt", params: map[string]string{"role": "app", "jwt": "sa-jwt"}},
+		{name: "mount", params: map[string]string{"role": "app", "jwt": "sa-jwt"}, mount: "k8s-prod"},
+		{name: "missing role", params: map[string]string{"jwt": "sa-jwt"}, wantErr: "'role' not supplied"},
+		{name: "missing token", params: map[string]string{"role": "app", "jwt_path": f.Name() + ".missing"}, wantErr: "service account token"},
+		{name: "invalid token", params: map[string]string{"role": "app", "jwt": "other"}, wantErr: "invalid"},
+	}
+	for _, tt := range kubernetesTests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := vault.Client("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Authenticate(context.Background(), Options{
+				Method: "kubernetes",
+				Mount:  tt.mount,
+				Params: tt.params,
+				Client: client,
+			})
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
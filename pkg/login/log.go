@@ -0,0 +1,103 @@
+package login
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// RedactedValue replaces the secrets in the logs.
+const RedactedValue = "[REDACTED]"
+
+// secretPattern matches the secrets recognizable by their format: Vault
+// service, batch and recovery tokens and JWTs.
+var secretPattern = regexp.MustCompile(`\b(?:[sbr]\.[A-Za-z0-9]{24,}|hv[sbr]\.[A-Za-z0-9_-]{24,})\b|\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+// sensitiveKeys are the fragments of the names of the parameters holding a
+// secret: tokens, passwords, secret_ids, JWTs, keys and credentials.
+var sensitiveKeys = []string{"token", "password", "passcode", "secret", "jwt", "key", "credentials", "pkcs7", "signature"}
+
+// Redact returns s with the Vault tokens and the JWTs replaced by
+// RedactedValue.
+func Redact(s string) string {
+	return secretPattern.ReplaceAllString(s, RedactedValue)
+}
+
+// redactParams returns a copy of the k=v parameters of a handler with the
+// values of the sensitive ones replaced by RedactedValue.
+func redactParams(m map[string]string) map[string]string {
+	redacted := make(map[string]string, len(m))
+	for k, v := range m {
+		redacted[k] = v
+		lower := strings.ToLower(k)
+		for _, s := range sensitiveKeys {
+			if strings.Contains(lower, s) {
+				redacted[k] = RedactedValue
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// redactWriter redacts every log line before writing it to out, as a last
+// line of defence for the secrets logged by mistake.
+type redactWriter struct {
+	out io.Writer
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewLogger returns a logger writing to out the messages of level (trace,
+// debug, info, warn or error), in JSON if json is set. The Vault tokens and
+// the JWTs are redacted from every message.
+func NewLogger(out io.Writer, level string, json bool) (hclog.Logger, error) {
+	l := hclog.LevelFromString(level)
+	if l == hclog.NoLevel {
+		return nil, fmt.Errorf("invalid log level %q, expected trace, debug, info, warn or error", level)
+	}
+	return hclog.New(&hclog.LoggerOptions{
+		Name:       "vauth",
+		Level:      l,
+		Output:     &redactWriter{out: out},
+		JSONFormat: json,
+	}), nil
+}
+
+// loggerOrNull returns logger or, if nil, a logger discarding the messages.
+func loggerOrNull(logger hclog.Logger) hclog.Logger {
+	if logger == nil {
+		return hclog.NewNullLogger()
+	}
+	return logger
+}
+
+// logTransport is an http.RoundTripper logging the method, the path, the
+// status and the latency of each request. The query string, the headers and
+// the bodies, which may hold secrets, are never logged.
+type logTransport struct {
+	logger hclog.Logger
+	base   http.RoundTripper
+}
+
+func (t *logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+	if err != nil {
+		t.logger.Debug("vault request failed", "method", req.Method, "path", req.URL.Path, "latency", latency, "error", err)
+		return nil, err
+	}
+	t.logger.Debug("vault request", "method", req.Method, "path", req.URL.Path, "status", resp.StatusCode, "latency", latency)
+	return resp, nil
+}
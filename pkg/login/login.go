@@ -0,0 +1,293 @@
+// Package login authenticates a client against Hashicorp Vault using the
+// same auth methods supported by the vauth CLI.
+//
+// It is meant to be embedded in Go programs that need the vauth login
+// behaviour without shelling out to the binary.
+package login
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	credGitHub "github.com/hashicorp/vault/builtin/credential/github"
+	credLdap "github.com/hashicorp/vault/builtin/credential/ldap"
+	credOkta "github.com/hashicorp/vault/builtin/credential/okta"
+	credToken "github.com/hashicorp/vault/builtin/credential/token"
+	credUserpass "github.com/hashicorp/vault/builtin/credential/userpass"
+)
+
+// Handler is the interface that any auth handlers must implement to enable
+// auth via vauth.
+type Handler interface {
+	Auth(*api.Client, map[string]string) (*api.Secret, error)
+	Help() string
+}
+
+// ContextHandler is implemented by the handlers performing requests outside
+// of the Vault client (e.g. to a CI token endpoint): Authenticate passes them
+// its context to cancel those requests too.
+type ContextHandler interface {
+	Handler
+	AuthContext(context.Context, *api.Client, map[string]string) (*api.Secret, error)
+}
+
+// ExplainHandler is implemented by the handlers able to describe, without
+// the secrets, the credentials they log in with (e.g. the AWS credential
+// source and the signed STS endpoint).
+type ExplainHandler interface {
+	ContextHandler
+	AuthExplain(context.Context, *api.Client, map[string]string, io.Writer) (*api.Secret, error)
+}
+
+// CertificateHandler is implemented by the handlers loading the client
+// certificate of the TLS connection themselves (e.g. from a PKCS#12 bundle):
+// Authenticate configures it on the Vault client it builds.
+type CertificateHandler interface {
+	Handler
+	ClientCertificate(map[string]string) (*tls.Certificate, error)
+}
+
+// Handlers is an k:v datatype with authentication method type and
+// the related vault Handler
+var Handlers = map[string]Handler{
+	"aws":        &AWSHandler{},
+	"azure":      &AzureHandler{},
+	"cert":       &CertHandler{},
+	"gcp":        &GCPHandler{},
+	"gha":        &GitHubActionsHandler{},
+	"github":     &credGitHub.CLIHandler{},
+	"gitlab":     &GitLabHandler{},
+	"kubernetes": &KubernetesHandler{},
+	"ldap":       &credLdap.CLIHandler{},
+	"okta":       &credOkta.CLIHandler{},
+	"radius": &credUserpass.CLIHandler{
+		DefaultMount: "radius",
+	},
+	"token": &credToken.CLIHandler{},
+	"userpass": &credUserpass.CLIHandler{
+		DefaultMount: "userpass",
+	},
+}
+
+// TokenSink is the destination of the token obtained by a successful login.
+// The InternalTokenHelper of the command/token package is a TokenSink.
+type TokenSink interface {
+	Store(token string) error
+}
+
+// Options configures an authentication performed by Authenticate.
+type Options struct {
+	// Method is the auth method type (e.g. userpass, token, ldap).
+	Method string
+
+	// Mount is the path where the auth method is enabled. When empty the
+	// handler default is used (e.g. userpass -> userpass/).
+	Mount string
+
+	// Params are the method specific k=v parameters, the same accepted by
+	// the Hashicorp Vault CLI login sub-command.
+	Params map[string]string
+
+	// TokenSink, if set, receives the token obtained by the login.
+	TokenSink TokenSink
+
+	// Config is used to build the Vault client when Client is nil. A nil
+	// Config means api.DefaultConfig plus the VAULT_* environment.
+	Config *api.Config
+
+	// Client, if set, is used as-is to talk to Vault. Retry applies only to
+	// the clients built by Authenticate from Config.
+	Client *api.Client
+
+	// Retry configures the retries of the requests failing with a transient
+	// error.
+	Retry RetryPolicy
+
+	// WaitForUnseal makes Authenticate wait, polling sys/health, until Vault
+	// is active and unsealed before logging in.
+	WaitForUnseal bool
+
+	// Explain, if set, receives the description of the credentials used by
+	// the handler. It requires a handler implementing ExplainHandler.
+	Explain io.Writer
+
+	// Logger, if set, receives the login events and the requests of the
+	// clients built by Authenticate. The secrets are never logged.
+	Logger hclog.Logger
+}
+
+// Authenticate logs in against Vault with the given options and returns the
+// resulting secret. If a TokenSink is configured the token is stored in it;
+// when that fails the secret is returned together with the error so the
+// caller can still use the token.
+//
+// The known failures are returned as an *Error matching its kind with
+// errors.Is, e.g. ErrAuthDenied for wrong credentials or ErrPersist when the
+// token is not stored.
+//
+// When ctx is done before the login completes Authenticate returns an
+// ErrTimeout wrapping context.DeadlineExceeded, or context.Canceled, and
+// nothing is stored in the TokenSink. The in-flight requests are aborted only
+// for the clients built by Authenticate or by NewClientWithContext.
+func Authenticate(ctx context.Context, opts Options) (*api.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, classifyError(err)
+	}
+	logger := loggerOrNull(opts.Logger)
+	handler, ok := Handlers[opts.Method]
+	if !ok {
+		return nil, newError(ErrUnsupportedMethod, "%s method not supported", opts.Method)
+	}
+	if _, ok := handler.(ExplainHandler); opts.Explain != nil && !ok {
+		return nil, newError(ErrUsage, "explain is not supported by the %s method", opts.Method)
+	}
+	authConfig, err := authParams(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	config := opts.Config
+	if h, ok := handler.(CertificateHandler); ok {
+		cert, err := h.ClientCertificate(authConfig)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil {
+			if opts.Client != nil {
+				return nil, newError(ErrUsage, "the %s client certificate requires a client built by Authenticate from Config", opts.Method)
+			}
+			if config == nil {
+				config = api.DefaultConfig()
+			}
+			if config, err = withClientCertificate(config, cert); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	client := opts.Client
+	if client == nil {
+		if client, err = newClient(ctx, config, opts.Retry, opts.Logger); err != nil {
+			return nil, err
+		}
+	}
+	if opts.WaitForUnseal {
+		if err := WaitForUnseal(ctx, client, opts.Retry); err != nil {
+			return nil, classifyError(err)
+		}
+	}
+
+	logger.Debug("authenticating", "method", opts.Method, "params", redactParams(authConfig))
+	sec, err := authWithContext(ctx, handler, client, authConfig, opts.Explain)
+	if err != nil {
+		logger.Error("authentication failed", "method", opts.Method, "error", err)
+		return nil, classifyError(err)
+	}
+	tokenID, err := sec.TokenID()
+	if err != nil || tokenID == "" {
+		logger.Error("authentication failed", "method", opts.Method, "error", "no token available")
+		return nil, newError(ErrAuthDenied, "no token available")
+	}
+	accessor, _ := sec.TokenAccessor()
+	policies, _ := sec.TokenPolicies()
+	ttl, _ := sec.TokenTTL()
+	logger.Info("authenticated", "method", opts.Method, "accessor", accessor, "policies", policies, "ttl", ttl)
+
+	if opts.TokenSink != nil {
+		if err := opts.TokenSink.Store(tokenID); err != nil {
+			logger.Error("error storing token", "error", err)
+			return sec, newError(ErrPersist, "error storing token: %w", err)
+		}
+	}
+	return sec, nil
+}
+
+// authWithContext runs the handler Auth returning as soon as ctx is done,
+// even if the handler is blocked (e.g. prompting for a password). A non-nil
+// explain is passed to the ExplainHandler.
+func authWithContext(ctx context.Context, handler Handler, client *api.Client, authConfig map[string]string, explain io.Writer) (*api.Secret, error) {
+	type result struct {
+		sec *api.Secret
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		if h, ok := handler.(ExplainHandler); ok && explain != nil {
+			r.sec, r.err = h.AuthExplain(ctx, client, authConfig, explain)
+		} else if h, ok := handler.(ContextHandler); ok {
+			r.sec, r.err = h.AuthContext(ctx, client, authConfig)
+		} else {
+			r.sec, r.err = handler.Auth(client, authConfig)
+		}
+		done <- r
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		// A request aborted by the context surfaces as a transport error
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return r.sec, r.err
+	}
+}
+
+// Help returns the help text of the handler registered for method or the
+// empty string if the method is not supported.
+func Help(method string) string {
+	handler, ok := Handlers[method]
+	if !ok {
+		return ""
+	}
+	return handler.Help()
+}
+
+// authParams builds the parameters passed to the auth handler, filling the
+// username and password from the environment for the methods requiring them.
+func authParams(opts Options) (map[string]string, error) {
+	authConfig := map[string]string{}
+	if opts.Method == "userpass" || opts.Method == "ldap" {
+		username, ok := opts.Params["username"]
+		if !ok {
+			username = UsernameFromEnv()
+			if username == "" {
+				return nil, fmt.Errorf("'username' not supplied and neither 'LOGNAME' nor 'USER' env vars set")
+			}
+		}
+		password, ok := opts.Params["password"]
+		if !ok {
+			password = PasswordFromEnv()
+		}
+		authConfig["username"] = username
+		authConfig["method"] = opts.Method
+		if password != "" {
+			authConfig["password"] = password
+		}
+	}
+	for k, v := range opts.Params {
+		authConfig[k] = v
+	}
+	if authConfig["mount"] == "" && opts.Mount != "" {
+		authConfig["mount"] = opts.Mount
+	}
+	return authConfig, nil
+}
+
+// NewClient return a new vault client configured from config and the
+// VAULT_* environment variables
+func NewClient(config *api.Config) (*api.Client, error) {
+	if config == nil {
+		config = api.DefaultConfig()
+	}
+	if err := config.ReadEnvironment(); err != nil {
+		return nil, fmt.Errorf("%s failed to read environment", err)
+	}
+	return api.NewClient(config)
+}
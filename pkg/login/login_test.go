@@ -0,0 +1,82 @@
+package login
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticateUnsupportedMethod(t *testing.T) {
+	_, err := Authenticate(context.Background(), Options{Method: "foo"})
+	if err == nil || !strings.Contains(err.Error(), "foo method not supported") {
+		t.Fatalf("expected unsupported method error, got %v", err)
+	}
+}
+
+func TestAuthenticateCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Authenticate(ctx, Options{Method: "token"}); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestAuthParams(t *testing.T) {
+	os.Unsetenv("LOGNAME")
+	os.Setenv("USER", "envuser")
+	defer os.Unsetenv("USER")
+	os.Setenv("PASSWORD", "envpassword")
+	defer os.Unsetenv("PASSWORD")
+
+	paramsTests := []struct {
+		name string
+		opts Options
+		want map[string]string
+	}{
+		{
+			name: "userpass from env",
+			opts: Options{Method: "userpass"},
+			want: map[string]string{"username": "envuser", "password": "envpassword", "method": "userpass"},
+		},
+		{
+			name: "ldap explicit params",
+			opts: Options{Method: "ldap", Params: map[string]string{"username": "test", "password": "test"}},
+			want: map[string]string{"username": "test", "password": "test", "method": "ldap"},
+		},
+		{
+			name: "token with mount",
+			opts: Options{Method: "token", Mount: "mytoken", Params: map[string]string{"token": "foo"}},
+			want: map[string]string{"token": "foo", "mount": "mytoken"},
+		},
+		{
+			name: "mount param wins",
+			opts: Options{Method: "token", Mount: "mytoken", Params: map[string]string{"mount": "other"}},
+			want: map[string]string{"mount": "other"},
+		},
+	}
+	for _, tt := range paramsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := authParams(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s: got %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthParamsMissingUsername(t *testing.T) {
+	os.Unsetenv("LOGNAME")
+	os.Unsetenv("USER")
+	if _, err := authParams(Options{Method: "userpass"}); err == nil {
+		t.Fatal("expected an error when no username is available")
+	}
+}
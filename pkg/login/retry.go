@@ -0,0 +1,192 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// DefaultRetryMinWait is the backoff of the first retry when the
+	// RetryPolicy does not set one.
+	DefaultRetryMinWait = 500 * time.Millisecond
+	// DefaultRetryMaxWait is the maximum backoff between two retries when
+	// the RetryPolicy does not set one.
+	DefaultRetryMaxWait = 30 * time.Second
+)
+
+// RetryPolicy configures the retries of the Vault requests failing with a
+// transient error: connection failures, 429 and 5xx responses. Auth failures
+// (e.g. "invalid username or password") are never retried.
+type RetryPolicy struct {
+	// Max is the number of retries after the first attempt. Zero disables
+	// the vauth retries and keeps the Vault client defaults.
+	Max int
+
+	// MinWait is the backoff before the first retry, doubled at every
+	// following retry.
+	MinWait time.Duration
+
+	// MaxWait caps the backoff between two retries.
+	MaxWait time.Duration
+}
+
+// backoff returns the exponential backoff with jitter before the retry
+// number attempt (starting from 0).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	min, max := p.MinWait, p.MaxWait
+	if min <= 0 {
+		min = DefaultRetryMinWait
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxWait
+	}
+	wait := max
+	if attempt < 32 && min<<uint(attempt) < max {
+		wait = min << uint(attempt)
+	}
+	// Equal jitter: half of the wait is fixed and half is random
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryAfter returns the delay requested by the server through the
+// Retry-After header, capped by MaxWait, or zero.
+func (p RetryPolicy) retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	max := p.MaxWait
+	if max <= 0 {
+		max = DefaultRetryMaxWait
+	}
+	if wait := time.Duration(seconds) * time.Second; wait < max {
+		return wait
+	}
+	return max
+}
+
+// shouldRetry reports whether a request that got resp or err failed with a
+// transient error worth retrying.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return isTransientError(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isTransientError reports whether err is a network failure (e.g. connection
+// refused or reset) rather than a permanent one such as a TLS verification
+// error.
+func isTransientError(err error) bool {
+	switch e := err.(type) {
+	case *net.OpError:
+		return true
+	case net.Error:
+		return e.Timeout()
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// retryTransport is an http.RoundTripper retrying the requests failing with
+// a transient error according to policy.
+type retryTransport struct {
+	policy RetryPolicy
+	base   http.RoundTripper
+	logger hclog.Logger
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := loggerOrNull(t.logger)
+	// Buffer the body to send it again at every attempt
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	for attempt := 0; ; attempt++ {
+		r := req.WithContext(req.Context())
+		if body != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		resp, err := t.base.RoundTrip(r)
+		if attempt >= t.policy.Max || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		wait := t.policy.retryAfter(resp)
+		if wait == 0 {
+			wait = t.policy.backoff(attempt)
+		}
+		if err != nil {
+			logger.Warn("retrying vault request", "method", req.Method, "path", req.URL.Path, "attempt", attempt+1, "wait", wait, "error", err)
+		} else {
+			logger.Warn("retrying vault request", "method", req.Method, "path", req.URL.Path, "attempt", attempt+1, "wait", wait, "status", resp.StatusCode)
+		}
+		if resp != nil {
+			// Drain the body to reuse the connection
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WaitForUnseal polls sys/health until Vault is initialized, unsealed and
+// active, waiting between two polls according to policy. Any error,
+// including a connection failure, is considered transient: WaitForUnseal
+// returns only when Vault is available or ctx is done.
+func WaitForUnseal(ctx context.Context, client *api.Client, policy RetryPolicy) error {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		health, err := client.Sys().Health()
+		if err == nil && health.Initialized && !health.Sealed && !health.Standby {
+			return nil
+		}
+		if err := sleep(ctx, policy.backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for d or until ctx is done, returning ctx.Err() in the latter
+// case.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,203 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestShouldRetry(t *testing.T) {
+	retryTests := []struct {
+		name   string
+		status int
+		err    error
+		want   bool
+	}{
+		{name: "ok", status: 200, want: false},
+		{name: "bad request", status: 400, want: false},
+		{name: "permission denied", status: 403, want: false},
+		{name: "too many requests", status: 429, want: true},
+		{name: "internal server error", status: 500, want: true},
+		{name: "not implemented", status: 501, want: false},
+		{name: "bad gateway", status: 502, want: true},
+		{name: "sealed", status: 503, want: true},
+		{name: "gateway timeout", status: 504, want: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, want: true},
+		{name: "generic error", err: fmt.Errorf("x509: certificate signed by unknown authority"), want: false},
+	}
+	for _, tt := range retryTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status}
+			}
+			if got := shouldRetry(resp, tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MinWait: 100 * time.Millisecond, MaxWait: time.Second}
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		got := p.backoff(attempt)
+		if got < want/2 || got > want {
+			t.Errorf("attempt %d: got %s, want between %s and %s", attempt, got, want/2, want)
+		}
+	}
+}
+
+// fakeServer answers with the given statuses, in order, and then with 200.
+// It returns the server and the counter of the received requests.
+func fakeServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method == "PUT" && !strings.Contains(string(body), `"password":"test"`) {
+			t.Errorf("unexpected body %q", body)
+		}
+		n := int(atomic.AddInt32(&calls, 1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			fmt.Fprintf(w, `{"errors":["status %d"]}`, statuses[n-1])
+			return
+		}
+		fmt.Fprint(w, `{"auth":{"client_token":"s.token","policies":["default"]}}`)
+	}))
+	return ts, &calls
+}
+
+func TestRetryTransport(t *testing.T) {
+	transportTests := []struct {
+		name       string
+		statuses   []int
+		max        int
+		wantStatus int
+		wantCalls  int32
+	}{
+		{name: "transient then ok", statuses: []int{503, 500, 429}, max: 3, wantStatus: 200, wantCalls: 4},
+		{name: "retries exhausted", statuses: []int{503, 503, 503}, max: 2, wantStatus: 503, wantCalls: 3},
+		{name: "auth failure not retried", statuses: []int{400}, max: 3, wantStatus: 400, wantCalls: 1},
+	}
+	for _, tt := range transportTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, calls := fakeServer(t, tt.statuses...)
+			defer ts.Close()
+
+			client := &http.Client{Transport: &retryTransport{
+				policy: RetryPolicy{Max: tt.max, MinWait: time.Millisecond, MaxWait: time.Millisecond},
+				base:   http.DefaultTransport,
+			}}
+			req, _ := http.NewRequest("PUT", ts.URL, strings.NewReader(`{"password":"test"}`))
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryTransportConnectionRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	client := &http.Client{Transport: &retryTransport{
+		policy: RetryPolicy{Max: 2, MinWait: time.Millisecond, MaxWait: time.Millisecond},
+		base:   http.DefaultTransport,
+	}}
+	start := time.Now()
+	if _, err := client.Get("http://" + addr); err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("retries took %s", elapsed)
+	}
+}
+
+func TestAuthenticateRetry(t *testing.T) {
+	authTests := []struct {
+		name      string
+		statuses  []int
+		wantErr   bool
+		wantCalls int32
+	}{
+		{name: "vault restarting", statuses: []int{503, 502}, wantCalls: 3},
+		{name: "invalid username or password", statuses: []int{400}, wantErr: true, wantCalls: 1},
+	}
+	for _, tt := range authTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, calls := fakeServer(t, tt.statuses...)
+			defer ts.Close()
+			config := api.DefaultConfig()
+			config.Address = ts.URL
+
+			_, err := Authenticate(context.Background(), Options{
+				Method: "userpass",
+				Params: map[string]string{"username": "test", "password": "test"},
+				Config: config,
+				Retry:  RetryPolicy{Max: 3, MinWait: time.Millisecond, MaxWait: time.Millisecond},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWaitForUnseal(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/health" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		sealed := atomic.AddInt32(&calls, 1) < 3
+		fmt.Fprintf(w, `{"initialized":true,"sealed":%t,"standby":false}`, sealed)
+	}))
+	defer ts.Close()
+
+	config := api.DefaultConfig()
+	config.Address = ts.URL
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := RetryPolicy{MinWait: time.Millisecond, MaxWait: time.Millisecond}
+	if err := WaitForUnseal(context.Background(), client, policy); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("got %d calls, want 3", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	atomic.StoreInt32(&calls, -1000)
+	if err := WaitForUnseal(ctx, client, policy); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}